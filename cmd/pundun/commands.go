@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/falkevik/pundun"
+)
+
+// command is a procedure that can be run from the command line or the
+// shell. args do not include the command name.
+type command struct {
+	usage  string
+	help   string
+	tables bool // the first argument is a table name
+	run    func(ctx context.Context, s pundun.Session, args []string) (interface{}, error)
+}
+
+var commands map[string]command
+
+// indexCommands are the subcommands of "index".
+var indexCommands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"tables": {"tables", "list the tables", false, listTables},
+		"create": {"create <table> <key> [options]", "create a table, e.g. create t '[\"id\"]' '{\"type\": \"rocksdb\"}'", false, createTable},
+		"drop":   {"drop <table>", "delete a table", true, dropTable},
+		"open":   {"open <table>", "open a table", true, openTable},
+		"close":  {"close <table>", "close a table", true, closeTable},
+		"info":   {"info <table> [attribute...]", "show table information", true, tableInfo},
+		"get":    {"get <table> <key>", "read the columns of a key", true, get},
+		"put":    {"put <table> <key> <columns>", "write the columns of a key", true, put},
+		"del":    {"del <table> <key>", "delete a key", true, del},
+		"update": {"update <table> <key> <operations>", "update columns, e.g. update t '{\"id\": 1}' " +
+			"'[{\"field\": \"n\", \"instruction\": \"increment\", \"value\": 1}]'", true, update},
+		"scan":  {"scan <table> [start] [end] [limit]", "read keys in order from start, or the first key, up to end or limit (100)", true, scan},
+		"index": {"index add|remove|read ...", "manage and read indexes", false, index},
+	}
+	indexCommands = map[string]command{
+		"add": {"index add <table> <configs>", "index columns, e.g. index add t " +
+			"'[{\"column\": \"text\", \"options\": {\"char_filter\": \"nfc\"}}]'", true, addIndex},
+		"remove": {"index remove <table> <column...>", "stop indexing columns", true, removeIndex},
+		"read":   {"index read <table> <column> <term> [filter]", "read the postings of a term", true, readIndex},
+	}
+}
+
+// run runs the command named by args[0].
+func run(ctx context.Context, s pundun.Session, out *printer, args []string) error {
+	if args[0] == "help" {
+		printHelp(out.w)
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see help", args[0])
+	}
+	res, err := cmd.run(ctx, s, args[1:])
+	if errors.Is(err, errUsage) {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	} else if err != nil {
+		return err
+	}
+	return out.print(res)
+}
+
+func printHelp(w io.Writer) {
+	var lines [][2]string
+	for name, cmd := range commands {
+		if name != "index" {
+			lines = append(lines, [2]string{cmd.usage, cmd.help})
+		}
+	}
+	for _, cmd := range indexCommands {
+		lines = append(lines, [2]string{cmd.usage, cmd.help})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][0] < lines[j][0] })
+	for _, l := range lines {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", l[0], l[1])
+	}
+}
+
+var errUsage = errors.New("usage")
+
+// nargs checks that there are between min and max arguments; a negative
+// max means no upper bound.
+func nargs(args []string, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return errUsage
+	}
+	return nil
+}
+
+func listTables(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 0, 0); err != nil {
+		return nil, err
+	}
+	tables, err := pundun.ListTablesContext(ctx, s)
+	sort.Strings(tables)
+	return tables, err
+}
+
+func createTable(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 2, 3); err != nil {
+		return nil, err
+	}
+	var key []string
+	if err := json.Unmarshal([]byte(args[1]), &key); err != nil {
+		return nil, fmt.Errorf("key: %v", err)
+	}
+	options := map[string]interface{}{}
+	if len(args) == 3 {
+		if err := parseJSON(args[2], &options); err != nil {
+			return nil, fmt.Errorf("options: %v", err)
+		}
+		number(options)
+	}
+	return pundun.CreateTableContext(ctx, s, args[0], key, options)
+}
+
+func dropTable(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 1, 1); err != nil {
+		return nil, err
+	}
+	return pundun.DeleteTableContext(ctx, s, args[0])
+}
+
+func openTable(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 1, 1); err != nil {
+		return nil, err
+	}
+	return pundun.OpenTableContext(ctx, s, args[0])
+}
+
+func closeTable(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 1, 1); err != nil {
+		return nil, err
+	}
+	return pundun.CloseTableContext(ctx, s, args[0])
+}
+
+func tableInfo(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 1, -1); err != nil {
+		return nil, err
+	}
+	return pundun.TableInfoContext(ctx, s, args[0], args[1:])
+}
+
+func get(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 2, 2); err != nil {
+		return nil, err
+	}
+	key, err := parseObject("key", args[1])
+	if err != nil {
+		return nil, err
+	}
+	columns, err := pundun.ReadContext(ctx, s, args[0], key)
+	if err != nil {
+		return nil, err
+	}
+	return pundun.KVP{Key: key, Columns: columns}, nil
+}
+
+func put(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 3, 3); err != nil {
+		return nil, err
+	}
+	key, err := parseObject("key", args[1])
+	if err != nil {
+		return nil, err
+	}
+	columns, err := parseObject("columns", args[2])
+	if err != nil {
+		return nil, err
+	}
+	return pundun.WriteContext(ctx, s, args[0], key, columns)
+}
+
+func del(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 2, 2); err != nil {
+		return nil, err
+	}
+	key, err := parseObject("key", args[1])
+	if err != nil {
+		return nil, err
+	}
+	return pundun.DeleteContext(ctx, s, args[0], key)
+}
+
+// updateOp is the JSON form of an update operation.
+type updateOp struct {
+	Field       string      `json:"field"`
+	Instruction string      `json:"instruction"`
+	Value       interface{} `json:"value"`
+	Default     interface{} `json:"default"`
+	Threshold   *uint32     `json:"threshold"`
+	SetValue    *uint32     `json:"setvalue"`
+}
+
+func update(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 3, 3); err != nil {
+		return nil, err
+	}
+	key, err := parseObject("key", args[1])
+	if err != nil {
+		return nil, err
+	}
+	var ops []updateOp
+	if err := parseJSON(args[2], &ops); err != nil {
+		return nil, fmt.Errorf("operations: %v", err)
+	}
+	upOps := make([]pundun.UpdateOperation, len(ops))
+	for i, op := range ops {
+		upOps[i] = pundun.UpdateOperation{
+			Field:        op.Field,
+			Value:        number(op.Value),
+			DefaultValue: number(op.Default),
+			Threshold:    op.Threshold,
+			SetValue:     op.SetValue,
+		}
+		switch op.Instruction {
+		case "increment":
+			upOps[i].Instruction = pundun.Increment
+		case "overwrite", "":
+			upOps[i].Instruction = pundun.Overwrite
+		default:
+			return nil, fmt.Errorf("unknown instruction %q", op.Instruction)
+		}
+	}
+	columns, err := pundun.UpdateContext(ctx, s, args[0], key, upOps)
+	if err != nil {
+		return nil, err
+	}
+	return pundun.KVP{Key: key, Columns: columns}, nil
+}
+
+func scan(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 1, 4); err != nil {
+		return nil, err
+	}
+	var keys []map[string]interface{}
+	limit := 100
+	for _, arg := range args[1:] {
+		if n, err := strconv.Atoi(arg); err == nil {
+			limit = n
+			continue
+		}
+		key, err := parseObject("key", arg)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) > 2 {
+		return nil, errUsage
+	}
+	var start map[string]interface{}
+	if len(keys) > 0 {
+		start = keys[0]
+	} else {
+		it, err := pundun.FirstContext(ctx, s, args[0])
+		if errors.Is(err, pundun.ErrEndOfTable) {
+			return pundun.KVL{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		start = it.Kvp.Key
+	}
+	if len(keys) == 2 {
+		return pundun.ReadRangeContext(ctx, s, args[0], start, keys[1], limit)
+	}
+	return pundun.ReadRangeNContext(ctx, s, args[0], start, limit)
+}
+
+func index(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+	cmd, ok := indexCommands[args[0]]
+	if !ok {
+		return nil, errUsage
+	}
+	res, err := cmd.run(ctx, s, args[1:])
+	if errors.Is(err, errUsage) {
+		return nil, fmt.Errorf("usage: %s", cmd.usage)
+	}
+	return res, err
+}
+
+func addIndex(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 2, 2); err != nil {
+		return nil, err
+	}
+	var indexes []pundun.IndexInfo
+	var list []map[string]interface{}
+	if err := parseJSON(args[1], &list); err != nil {
+		return nil, fmt.Errorf("configs: %v", err)
+	}
+	for _, m := range list {
+		number(m)
+		column, _ := m["column"].(string)
+		options, _ := m["options"].(map[string]interface{})
+		indexes = append(indexes, pundun.IndexInfo{Column: column, Options: options})
+	}
+	configs := make([]pundun.IndexConfig, len(indexes))
+	for i, index := range indexes {
+		configs[i] = index.Config()
+	}
+	return pundun.AddIndexContext(ctx, s, args[0], configs)
+}
+
+func removeIndex(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 2, -1); err != nil {
+		return nil, err
+	}
+	return pundun.RemoveIndexContext(ctx, s, args[0], args[1:])
+}
+
+// postingFilter is the JSON form of a posting filter.
+type postingFilter struct {
+	SortBy      string `json:"sort_by"`
+	StartTs     uint32 `json:"start_ts"`
+	EndTs       uint32 `json:"end_ts"`
+	MaxPostings uint32 `json:"max_postings"`
+}
+
+func readIndex(ctx context.Context, s pundun.Session, args []string) (interface{}, error) {
+	if err := nargs(args, 3, 4); err != nil {
+		return nil, err
+	}
+	var f postingFilter
+	if len(args) == 4 {
+		if err := json.Unmarshal([]byte(args[3]), &f); err != nil {
+			return nil, fmt.Errorf("filter: %v", err)
+		}
+	}
+	pf := pundun.PostingFilter{StartTs: f.StartTs, EndTs: f.EndTs, MaxPostings: f.MaxPostings}
+	switch f.SortBy {
+	case "relevance", "":
+		pf.SortBy = pundun.RELEVANCE
+	case "timestamp":
+		pf.SortBy = pundun.TIMESTAMP
+	default:
+		return nil, fmt.Errorf("unknown sort_by %q", f.SortBy)
+	}
+	return pundun.IndexReadContext(ctx, s, args[0], args[1], args[2], pf)
+}
+
+// parseJSON decodes s into v, keeping numbers as json.Number; see number.
+func parseJSON(s string, v interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func parseObject(what, s string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := parseJSON(s, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", what, err)
+	}
+	number(m)
+	return m, nil
+}
+
+// number converts the JSON numbers of v to int64 when integral and to
+// float64 otherwise.
+func number(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, e := range v {
+			v[i] = number(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = number(e)
+		}
+	}
+	return v
+}
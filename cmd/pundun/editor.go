@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by readLine when the line is abandoned
+// with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// editor reads lines from a terminal in raw mode, with cursor movement,
+// history and completion.
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	// complete returns the candidates for the word ending at the end
+	// of line.
+	complete func(line string) []string
+}
+
+// Control keys understood by the editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// readLine reads a line after writing prompt. It returns io.EOF on
+// Ctrl-D at an empty line and errInterrupted on Ctrl-C.
+func (e *editor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	hist := len(e.history)
+	saved := ""
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+		redraw()
+	}
+	redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case keyCR, keyLF:
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyBackspace, keyDelete:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(line)
+		case keyCtrlB:
+			if pos > 0 {
+				pos--
+			}
+		case keyCtrlF:
+			if pos < len(line) {
+				pos++
+			}
+		case keyCtrlK:
+			line = line[:pos]
+		case keyCtrlU:
+			line = line[pos:]
+			pos = 0
+		case keyCtrlP, keyCtrlN:
+			hist, saved = e.browse(r == keyCtrlP, hist, saved, string(line), setLine)
+			continue
+		case keyTab:
+			line, pos = e.completeLine(prompt, line, pos)
+		case keyEscape:
+			switch e.escape() {
+			case 'A':
+				hist, saved = e.browse(true, hist, saved, string(line), setLine)
+				continue
+			case 'B':
+				hist, saved = e.browse(false, hist, saved, string(line), setLine)
+				continue
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3':
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r == utf8.RuneError || !unicode.IsPrint(r) {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final
+// byte, or '3' for the delete key.
+func (e *editor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if r >= '0' && r <= '9' {
+		// Sequences such as "\x1b[3~" end with a tilde.
+		for {
+			t, _, err := e.in.ReadRune()
+			if err != nil || t == '~' {
+				break
+			}
+		}
+	}
+	return r
+}
+
+// browse moves through the history, keeping the edited line as the
+// entry after the last one.
+func (e *editor) browse(up bool, hist int, saved, current string, setLine func(string)) (int, string) {
+	if hist == len(e.history) {
+		saved = current
+	}
+	if up && hist > 0 {
+		hist--
+	} else if !up && hist < len(e.history) {
+		hist++
+	} else {
+		return hist, saved
+	}
+	if hist == len(e.history) {
+		setLine(saved)
+	} else {
+		setLine(e.history[hist])
+	}
+	return hist, saved
+}
+
+// completeLine completes the word before the cursor. A single candidate
+// replaces the word, several candidates extend it to their common prefix
+// or are listed below the line.
+func (e *editor) completeLine(prompt string, line []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return line, pos
+	}
+	before := string(line[:pos])
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	candidates := e.complete(before)
+	if len(candidates) == 0 {
+		return line, pos
+	}
+	replacement := candidates[0]
+	if len(candidates) == 1 {
+		replacement += " "
+	} else {
+		for _, c := range candidates[1:] {
+			for !strings.HasPrefix(c, replacement) {
+				replacement = replacement[:len(replacement)-1]
+			}
+		}
+		if replacement == word {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		}
+	}
+	rest := line[pos:]
+	head := []rune(before[:start] + replacement)
+	return append(head, rest...), len(head)
+}
+
+// addHistory appends a line to the history, skipping blank lines and
+// repetitions of the previous line.
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
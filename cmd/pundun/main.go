@@ -0,0 +1,106 @@
+// Command pundun is a command-line client for pundun nodes.
+//
+// Usage:
+//
+//	pundun [flags] [command [arguments]]
+//
+// Keys, columns and options are given as JSON, e.g.
+//
+//	pundun put users '{"id": 1}' '{"name": "ada"}'
+//
+// Without a command, pundun starts an interactive shell with line
+// editing, history and tab completion of commands and table names.
+// Run "pundun help" for the list of commands.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/falkevik/pundun"
+)
+
+func main() {
+	host := flag.String("host", "127.0.0.1:8887", "address of the pundun node")
+	user := flag.String("user", "admin", "user name")
+	pass := flag.String("pass", "admin", "password")
+	caFile := flag.String("ca", "", "PEM file with the CA certificates of the node")
+	certFile := flag.String("cert", "", "PEM file with the client certificate")
+	keyFile := flag.String("key", "", "PEM file with the client key")
+	insecure := flag.Bool("insecure", false, "skip verification of the node's certificate")
+	format := flag.String("o", "table", "output format: table, json or yaml")
+	reqTimeout := flag.Duration("timeout", 0, "request timeout, 30s when zero")
+	histFile := flag.String("history", defaultHistory(), "history file of the interactive shell")
+	verbose := flag.Bool("v", false, "log connection events")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: pundun [flags] [command [arguments]]\n\nflags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\ncommands:\n")
+		printHelp(flag.CommandLine.Output())
+	}
+	flag.Parse()
+
+	out, err := newPrinter(os.Stdout, *format)
+	if err != nil {
+		fatal(err)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	if flag.Arg(0) == "help" {
+		// No node is needed to list the commands.
+		printHelp(out.w)
+		return
+	}
+
+	conf := pundun.Config{RequestTimeout: *reqTimeout, DialTimeout: 10 * time.Second}
+	if *caFile != "" || *certFile != "" {
+		if conf.TLS, err = pundun.NewTLSConfig(*caFile, *certFile, *keyFile); err != nil {
+			fatal(err)
+		}
+	}
+	if *insecure {
+		if conf.TLS == nil {
+			conf.TLS = &tls.Config{}
+		}
+		conf.TLS.InsecureSkipVerify = true
+	}
+	s, err := pundun.ConnectWithConfig(*host, *user, *pass, conf)
+	if err != nil {
+		fatal(err)
+	}
+	defer pundun.Disconnect(s)
+
+	if flag.NArg() == 0 {
+		if err := repl(s, out, *histFile); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, s, out, flag.Args()); err != nil {
+		fatal(err)
+	}
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pundun_history")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "pundun:", err)
+	os.Exit(1)
+}
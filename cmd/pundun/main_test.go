@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falkevik/pundun"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`put  my_table {"id": 1, "s": "a b\"}"} 'two words' [1, 2]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"put", "my_table", `{"id": 1, "s": "a b\"}"}`, "two words", "[1, 2]"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected %q, got %q", want, args)
+	}
+	if _, err := splitArgs(`get t {"id": 1`); err == nil {
+		t.Fatal("expected an error for unbalanced brackets")
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	tables := func() []string { return []string{"users", "usage", "items"} }
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"sc", []string{"scan"}},
+		{"get us", []string{"usage", "users"}},
+		{"index r", []string{"read", "remove"}},
+		{"index read i", []string{"items"}},
+		{"create u", nil},
+		{"get users ", nil},
+	}
+	for _, c := range cases {
+		if got := completions(c.line, tables); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: expected %v, got %v", c.line, c.want, got)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	// Complete a table name, edit the line, then recall it from history.
+	input := "get us\ter\t\x7f\x7fs\r" + "\x1b[A\x1b[D\x1b[D\x1b[D\x01\x1b[3~\x1b[3~\x1b[3~del\r"
+	var out bytes.Buffer
+	e := &editor{
+		in:  bufio.NewReader(strings.NewReader(input)),
+		out: &out,
+		complete: func(line string) []string {
+			return completions(line, func() []string { return []string{"users", "usage"} })
+		},
+	}
+	line, err := e.readLine("> ")
+	if err != nil || line != "get users" {
+		t.Fatalf("expected completed line, got %q, %v", line, err)
+	}
+	e.addHistory(line)
+	line, err = e.readLine("> ")
+	if err != nil || line != "del users" {
+		t.Fatalf("expected edited history line, got %q, %v", line, err)
+	}
+	if _, err := e.readLine("> "); err == nil {
+		t.Fatal("expected end of input")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	kvl := pundun.KVL{List: []pundun.KVP{
+		{Key: map[string]interface{}{"id": int64(1)}, Columns: map[string]interface{}{"name": "ada", "tags": []interface{}{"a"}}},
+		{Key: map[string]interface{}{"id": int64(2)}, Columns: map[string]interface{}{"bin": []byte{1}}},
+	}}
+	var out bytes.Buffer
+	p, _ := newPrinter(&out, "table")
+	p.print(kvl)
+	want := "ID  BIN   NAME  TAGS\n1         ada   [\"a\"]\n2   0x01        \n"
+	if out.String() != want {
+		t.Fatalf("unexpected table output:\n%s", out.String())
+	}
+
+	out.Reset()
+	p, _ = newPrinter(&out, "yaml")
+	p.print(kvl.List[0])
+	want = "columns:\n  name: ada\n  tags:\n    - a\nkey:\n  id: 1\n"
+	if out.String() != want {
+		t.Fatalf("unexpected yaml output:\n%s", out.String())
+	}
+
+	out.Reset()
+	p.print(map[string]interface{}{"s": "true", "e": "", "n": nil, "l": []interface{}{map[string]interface{}{"a": 1.5}}})
+	want = "e: \"\"\nl:\n  -\n    a: 1.5\nn: null\ns: \"true\"\n"
+	if out.String() != want {
+		t.Fatalf("unexpected yaml output:\n%s", out.String())
+	}
+}
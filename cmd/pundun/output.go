@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/falkevik/pundun"
+)
+
+// printer writes command results in one of the output formats.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table", "json", "yaml":
+		return &printer{w, format}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+func (p *printer) print(res interface{}) error {
+	v := plain(res)
+	switch p.format {
+	case "json":
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		return writeYAML(p.w, v)
+	}
+	return p.table(res, v)
+}
+
+// plain converts results to maps, lists and values only.
+func plain(res interface{}) interface{} {
+	switch r := res.(type) {
+	case pundun.KVP:
+		return map[string]interface{}{"key": r.Key, "columns": r.Columns}
+	case pundun.KVL:
+		list := make([]interface{}, len(r.List))
+		for i, kvp := range r.List {
+			list[i] = plain(kvp)
+		}
+		m := map[string]interface{}{"list": list}
+		if len(r.Continuation) > 0 {
+			m["continuation"] = r.Continuation
+		}
+		return m
+	case pundun.Iterator:
+		return plain(r.Kvp)
+	case []pundun.Posting:
+		list := make([]interface{}, len(r))
+		for i, p := range r {
+			list[i] = map[string]interface{}{
+				"key":       p.Key,
+				"timestamp": p.Timestamp,
+				"frequency": p.Frequency,
+				"position":  p.Position,
+			}
+		}
+		return list
+	case int:
+		if r == pundun.OK {
+			return "ok"
+		}
+	}
+	return res
+}
+
+// table prints rows of key columns pairs and postings as a table with a
+// column per field, and other results as name and value pairs.
+func (p *printer) table(res, v interface{}) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	var rows []map[string]interface{}
+	var header []string
+	switch r := res.(type) {
+	case pundun.KVP:
+		rows, header = kvpRows([]pundun.KVP{r})
+	case pundun.KVL:
+		rows, header = kvpRows(r.List)
+	case pundun.Iterator:
+		rows, header = kvpRows([]pundun.KVP{r.Kvp})
+	case []pundun.Posting:
+		for _, e := range v.([]interface{}) {
+			rows = append(rows, e.(map[string]interface{}))
+		}
+		header = []string{"key", "timestamp", "frequency", "position"}
+	case []string:
+		for _, s := range r {
+			fmt.Fprintln(tw, s)
+		}
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(r))
+		for k := range r {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%s\n", k, cell(r[k]))
+		}
+		return nil
+	default:
+		fmt.Fprintln(tw, cell(v))
+		return nil
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for _, row := range rows {
+		cells := make([]string, len(header))
+		for i, h := range header {
+			if v, ok := row[h]; ok {
+				cells[i] = cell(v)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return nil
+}
+
+// kvpRows flattens key columns pairs into rows and returns them with the
+// key fields followed by the columns found in any row.
+func kvpRows(list []pundun.KVP) ([]map[string]interface{}, []string) {
+	keys := map[string]bool{}
+	columns := map[string]bool{}
+	rows := make([]map[string]interface{}, len(list))
+	for i, kvp := range list {
+		row := map[string]interface{}{}
+		for k, v := range kvp.Columns {
+			row[k] = v
+			columns[k] = true
+		}
+		for k, v := range kvp.Key {
+			row[k] = v
+			keys[k] = true
+		}
+		rows[i] = row
+	}
+	header := sortedKeys(keys)
+	for _, c := range sortedKeys(columns) {
+		if !keys[c] {
+			header = append(header, c)
+		}
+	}
+	return rows, header
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cell formats a value for a table cell.
+func cell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case []byte:
+		return "0x" + fmt.Sprintf("%x", v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// writeYAML writes v as a YAML document.
+func writeYAML(w io.Writer, v interface{}) error {
+	var b strings.Builder
+	yamlValue(&b, v, 0, false)
+	if !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// yamlValue writes v at the given indentation. inline is set when v
+// follows a "key:" or "- " on the same line.
+func yamlValue(b *strings.Builder, v interface{}, indent int, inline bool) {
+	pad := strings.Repeat("  ", indent)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if inline {
+			b.WriteString("\n")
+		}
+		for _, k := range keys {
+			b.WriteString(pad + yamlScalar(k) + ":")
+			yamlValue(b, v[k], indent+1, true)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		if inline {
+			b.WriteString("\n")
+		}
+		for _, e := range v {
+			b.WriteString(pad + "-")
+			yamlValue(b, e, indent+1, true)
+		}
+	case []string:
+		l := make([]interface{}, len(v))
+		for i, s := range v {
+			l[i] = s
+		}
+		yamlValue(b, l, indent, inline)
+	default:
+		if inline {
+			b.WriteString(" ")
+		}
+		b.WriteString(yamlScalar(v) + "\n")
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if yamlPlain(v) {
+			return v
+		}
+		return strconv.Quote(v)
+	case []byte:
+		return "!!binary " + base64.StdEncoding.EncodeToString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// yamlPlain reports whether s can be written unquoted without being
+// read back as another type or as YAML syntax.
+func yamlPlain(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off":
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return false
+	}
+	return !strings.Contains(s, ": ") && !strings.Contains(s, " #") && !strings.ContainsAny(s, "\n\t")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/falkevik/pundun"
+)
+
+// Number of history lines kept in the history file.
+const (
+	historySize = 1000
+)
+
+// repl runs the interactive shell until exit or end of input.
+func repl(s pundun.Session, out *printer, histFile string) error {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		// Not a terminal: run the commands read from stdin.
+		return runLines(s, out, bufio.NewScanner(os.Stdin))
+	}
+	restore()
+
+	tables := &tableCache{s: s}
+	e := &editor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		history:  loadHistory(histFile),
+		complete: func(line string) []string { return completions(line, tables.names) },
+	}
+	fmt.Fprintln(os.Stdout, `Type "help" for the list of commands, "exit" to quit.`)
+	for {
+		restore, err := makeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		line, err := e.readLine("pundun> ")
+		restore()
+		if err == errInterrupted {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		e.addHistory(line)
+		saveHistory(histFile, e.history)
+		if done := runLine(s, out, line, tables); done {
+			return nil
+		}
+	}
+}
+
+// runLines runs each line of sc as a command.
+func runLines(s pundun.Session, out *printer, sc *bufio.Scanner) error {
+	for sc.Scan() {
+		if done := runLine(s, out, sc.Text(), nil); done {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// runLine runs the command on line and reports whether the shell should
+// exit. Errors are printed. Ctrl-C cancels a running command.
+func runLine(s pundun.Session, out *printer, line string, tables *tableCache) bool {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, s, out, args); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	if tables != nil && (args[0] == "create" || args[0] == "drop") {
+		tables.invalidate()
+	}
+	return false
+}
+
+// splitArgs splits a shell line into arguments at white space. Single
+// or double quotes group words into one argument and are removed, while
+// JSON objects and arrays are kept whole, quotes included.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote && !escaped {
+				quote = 0
+				if depth > 0 {
+					cur.WriteRune(r)
+				}
+				continue
+			}
+			// Escapes are only meaningful inside JSON strings and are
+			// kept as they are.
+			escaped = r == '\\' && !escaped && depth > 0
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+			if depth > 0 {
+				cur.WriteRune(r)
+			}
+		case r == '{' || r == '[':
+			depth++
+			inArg = true
+			cur.WriteRune(r)
+		case r == '}' || r == ']':
+			if depth > 0 {
+				depth--
+			}
+			cur.WriteRune(r)
+		case (r == ' ' || r == '\t') && depth == 0:
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			inArg = true
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// completions returns the candidates for the last word of line: command
+// names, index subcommands or table names.
+func completions(line string, tables func() []string) []string {
+	words, err := splitArgs(line)
+	if err != nil {
+		return nil
+	}
+	if len(line) == 0 || line[len(line)-1] == ' ' || line[len(line)-1] == '\t' {
+		words = append(words, "")
+	}
+	n := len(words) - 1
+	word := words[n]
+	var names []string
+	switch {
+	case n == 0:
+		names = []string{"exit", "help", "quit"}
+		for name := range commands {
+			names = append(names, name)
+		}
+	case words[0] == "index" && n == 1:
+		for name := range indexCommands {
+			names = append(names, name)
+		}
+	case words[0] == "index" && n == 2:
+		if _, ok := indexCommands[words[1]]; ok {
+			names = tables()
+		}
+	case n == 1:
+		if cmd, ok := commands[words[0]]; ok && cmd.tables {
+			names = tables()
+		}
+	}
+	var candidates []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// tableCache keeps the table names for completion for a short while.
+type tableCache struct {
+	s       pundun.Session
+	list    []string
+	fetched time.Time
+}
+
+func (c *tableCache) names() []string {
+	if c.list == nil || time.Since(c.fetched) > 30*time.Second {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if list, err := pundun.ListTablesContext(ctx, c.s); err == nil {
+			c.list = list
+			c.fetched = time.Now()
+		}
+	}
+	return c.list
+}
+
+func (c *tableCache) invalidate() {
+	c.list = nil
+}
+
+func loadHistory(file string) []string {
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) > historySize {
+		lines = lines[len(lines)-historySize:]
+	}
+	return lines
+}
+
+func saveHistory(file string, history []string) {
+	if file == "" {
+		return
+	}
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	os.WriteFile(file, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
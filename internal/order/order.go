@@ -0,0 +1,115 @@
+// Package order defines the order of apollo values shared by the client
+// and the in-memory engine of punduntest, which follows the order of a
+// pundun node.
+package order
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// Compare orders two values: null before numbers, then booleans,
+// strings, binaries, lists and maps. Values of the same type compare by
+// value, lists element-wise and maps by their sorted entries. A nil
+// value is ordered as null.
+func Compare(a, b *apollo.Value) int {
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return compareInts(ra, rb)
+	}
+	switch x := a.GetType().(type) {
+	case *apollo.Value_Int:
+		if y, ok := b.GetType().(*apollo.Value_Int); ok {
+			return compareInts(x.Int, y.Int)
+		}
+		return compareFloats(float64(x.Int), b.GetDouble())
+	case *apollo.Value_Double:
+		if y, ok := b.GetType().(*apollo.Value_Int); ok {
+			return compareFloats(x.Double, float64(y.Int))
+		}
+		return compareFloats(x.Double, b.GetDouble())
+	case *apollo.Value_Boolean:
+		y := b.GetBoolean()
+		if x.Boolean == y {
+			return 0
+		} else if !x.Boolean {
+			return -1
+		}
+		return 1
+	case *apollo.Value_String_:
+		return strings.Compare(x.String_, b.GetString_())
+	case *apollo.Value_Binary:
+		return bytes.Compare(x.Binary, b.GetBinary())
+	case *apollo.Value_List:
+		xs, ys := x.List.GetValues(), b.GetList().GetValues()
+		for i := 0; i < len(xs) && i < len(ys); i++ {
+			if c := Compare(xs[i], ys[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(xs), len(ys))
+	case *apollo.Value_Map:
+		xm, ym := x.Map.GetValues(), b.GetMap().GetValues()
+		xk, yk := sortedNames(xm), sortedNames(ym)
+		for i := 0; i < len(xk) && i < len(yk); i++ {
+			if c := strings.Compare(xk[i], yk[i]); c != 0 {
+				return c
+			}
+			if c := Compare(xm[xk[i]], ym[yk[i]]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(xk), len(yk))
+	}
+	return 0
+}
+
+func rank(v *apollo.Value) int {
+	switch v.GetType().(type) {
+	case nil, *apollo.Value_Null:
+		return 0
+	case *apollo.Value_Int, *apollo.Value_Double:
+		return 1
+	case *apollo.Value_Boolean:
+		return 2
+	case *apollo.Value_String_:
+		return 3
+	case *apollo.Value_Binary:
+		return 4
+	case *apollo.Value_List:
+		return 5
+	case *apollo.Value_Map:
+		return 6
+	}
+	return 7
+}
+
+func compareInts[T int | int64](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func sortedNames(m map[string]*apollo.Value) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,18 +2,27 @@
 package pundun
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
-	//"fmt"
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/pundunlabs/apollo"
 	"log"
+	"math"
 	//"reflect"
+	"time"
 )
 
 //Default timeout value for database procedures.
 const (
-	timeout = 60
+	timeout = 30 * time.Second
+)
+
+// Number of times an idempotent procedure is replayed on a
+// resilient session after its connection was lost.
+const (
+	maxReplays = 3
 )
 
 type Tda struct {
@@ -29,6 +38,37 @@ type Wrapper struct {
 	SizeMargin   SizeMargin
 }
 
+// TableOptions are the options of a pundun table given on creation.
+// Unset fields are left to the node's defaults.
+type TableOptions struct {
+	Type              *int   // Leveldb, MemLeveldb, ..., Rocksdb
+	DataModel         string // "kv", "array" or "map"
+	Wrapper           *Wrapper
+	Tda               *Tda
+	HashingMethod     *int   // VirtualNodes, Consistent, Uniform or Rendezvous
+	Comparator        string // "ascending" or "descending"
+	TimeSeries        *bool
+	Distributed       *bool
+	NumOfShards       uint32
+	ReplicationFactor uint32
+	HashExclude       []string
+	TTL               uint32 // seconds
+}
+
+// Int returns a pointer to v, for setting optional int fields.
+func Int(v int) *int {
+	return &v
+}
+
+// Bool returns a pointer to v, for setting optional bool fields.
+func Bool(v bool) *bool {
+	return &v
+}
+
+// ErrInvalidOption is wrapped by errors for unknown table options or
+// option values of the wrong type.
+var ErrInvalidOption = errors.New("pundun: invalid table option")
+
 const (
 	Second      = 0
 	Millisecond = 1
@@ -203,9 +243,32 @@ func tidServe(tid, max int32, req chan string, resp chan int32) {
 	}
 }
 
-// Create a pundun table.
+// Create a pundun table. Options are given by name as described for
+// ParseTableOptions.
 func CreateTable(s Session, tableName string, key []string, options map[string]interface{}) (interface{}, error) {
-	tableOptions := fixOptions(options)
+	return CreateTableContext(context.Background(), s, tableName, key, options)
+}
+
+// CreateTableContext is like CreateTable but returns ctx.Err() as soon as ctx is done.
+func CreateTableContext(ctx context.Context, s Session, tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	opts, err := ParseTableOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return CreateTableWithOptionsContext(ctx, s, tableName, key, opts)
+}
+
+// Create a pundun table with typed options.
+func CreateTableWithOptions(s Session, tableName string, key []string, opts TableOptions) (interface{}, error) {
+	return CreateTableWithOptionsContext(context.Background(), s, tableName, key, opts)
+}
+
+// CreateTableWithOptionsContext is like CreateTableWithOptions but returns ctx.Err() as soon as ctx is done.
+func CreateTableWithOptionsContext(ctx context.Context, s Session, tableName string, key []string, opts TableOptions) (interface{}, error) {
+	tableOptions, err := fixTableOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	createTable := &apollo.CreateTable{
 		TableName:    *proto.String(tableName),
@@ -221,12 +284,17 @@ func CreateTable(s Session, tableName string, key []string, options map[string]i
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Delete a pundun table.
 func DeleteTable(s Session, tableName string) (interface{}, error) {
+	return DeleteTableContext(context.Background(), s, tableName)
+}
+
+// DeleteTableContext is like DeleteTable but returns ctx.Err() as soon as ctx is done.
+func DeleteTableContext(ctx context.Context, s Session, tableName string) (interface{}, error) {
 	deleteTable := &apollo.DeleteTable{
 		TableName: *proto.String(tableName),
 	}
@@ -238,12 +306,17 @@ func DeleteTable(s Session, tableName string) (interface{}, error) {
 	pdu := &apollo.ApolloPdu{
 		Procedure: procedure,
 	}
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Open a pundun table.
 func OpenTable(s Session, tableName string) (interface{}, error) {
+	return OpenTableContext(context.Background(), s, tableName)
+}
+
+// OpenTableContext is like OpenTable but returns ctx.Err() as soon as ctx is done.
+func OpenTableContext(ctx context.Context, s Session, tableName string) (interface{}, error) {
 	openTable := &apollo.OpenTable{
 		TableName: *proto.String(tableName),
 	}
@@ -256,12 +329,17 @@ func OpenTable(s Session, tableName string) (interface{}, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Close a pundun table.
 func CloseTable(s Session, tableName string) (interface{}, error) {
+	return CloseTableContext(context.Background(), s, tableName)
+}
+
+// CloseTableContext is like CloseTable but returns ctx.Err() as soon as ctx is done.
+func CloseTableContext(ctx context.Context, s Session, tableName string) (interface{}, error) {
 	closeTable := &apollo.CloseTable{
 		TableName: *proto.String(tableName),
 	}
@@ -274,12 +352,17 @@ func CloseTable(s Session, tableName string) (interface{}, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Retrieve table information.
 func TableInfo(s Session, tableName string, attrs []string) (interface{}, error) {
+	return TableInfoContext(context.Background(), s, tableName, attrs)
+}
+
+// TableInfoContext is like TableInfo but returns ctx.Err() as soon as ctx is done.
+func TableInfoContext(ctx context.Context, s Session, tableName string, attrs []string) (interface{}, error) {
 	attributes := fixAttributes(attrs)
 	tableInfo := &apollo.TableInfo{
 		TableName:  *proto.String(tableName),
@@ -294,27 +377,19 @@ func TableInfo(s Session, tableName string, attrs []string) (interface{}, error)
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Read a key from pundun table.
 func Read(s Session, tableName string, key map[string]interface{}) (map[string]interface{}, error) {
-	keyFields := fixFields(key)
-	read := &apollo.Read{
-		TableName: *proto.String(tableName),
-		Key:       keyFields,
-	}
-
-	procedure := &apollo.ApolloPdu_Read{
-		Read: read,
-	}
-
-	pdu := &apollo.ApolloPdu{
-		Procedure: procedure,
-	}
+	return ReadContext(context.Background(), s, tableName, key)
+}
 
-	res, err := run_transaction(s, pdu)
+// ReadContext is like Read but returns ctx.Err() as soon as ctx is done.
+func ReadContext(ctx context.Context, s Session, tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	pdu := readPdu(tableName, key)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return map[string]interface{}{}, err
 	}
@@ -323,45 +398,25 @@ func Read(s Session, tableName string, key map[string]interface{}) (map[string]i
 
 // Write key and columns to a pundun table.
 func Write(s Session, tableName string, key, columns map[string]interface{}) (interface{}, error) {
-	keyFields := fixFields(key)
-	columnFields := fixFields(columns)
-	write := &apollo.Write{
-		TableName: *proto.String(tableName),
-		Key:       keyFields,
-		Columns:   columnFields,
-	}
-
-	procedure := &apollo.ApolloPdu_Write{
-		Write: write,
-	}
-
-	pdu := &apollo.ApolloPdu{
-		Procedure: procedure,
-	}
+	return WriteContext(context.Background(), s, tableName, key, columns)
+}
 
-	res, err := run_transaction(s, pdu)
+// WriteContext is like Write but returns ctx.Err() as soon as ctx is done.
+func WriteContext(ctx context.Context, s Session, tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	pdu := writePdu(tableName, key, columns)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Update a key's columns on a pundun table.
 func Update(s Session, tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
-	keyFields := fixFields(key)
-	updateOperations := fixUpdateOperations(upOps)
-	update := &apollo.Update{
-		TableName:       *proto.String(tableName),
-		Key:             keyFields,
-		UpdateOperation: updateOperations,
-	}
-
-	procedure := &apollo.ApolloPdu_Update{
-		Update: update,
-	}
-
-	pdu := &apollo.ApolloPdu{
-		Procedure: procedure,
-	}
+	return UpdateContext(context.Background(), s, tableName, key, upOps)
+}
 
-	res, err := run_transaction(s, pdu)
+// UpdateContext is like Update but returns ctx.Err() as soon as ctx is done.
+func UpdateContext(ctx context.Context, s Session, tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
+	pdu := updatePdu(tableName, key, upOps)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return map[string]interface{}{}, err
 	}
@@ -370,27 +425,24 @@ func Update(s Session, tableName string, key map[string]interface{}, upOps []Upd
 
 // Delete a key from pundun table.
 func Delete(s Session, tableName string, key map[string]interface{}) (interface{}, error) {
-	keyFields := fixFields(key)
-	delete := &apollo.Delete{
-		TableName: *proto.String(tableName),
-		Key:       keyFields,
-	}
-
-	procedure := &apollo.ApolloPdu_Delete{
-		Delete: delete,
-	}
-
-	pdu := &apollo.ApolloPdu{
-		Procedure: procedure,
-	}
+	return DeleteContext(context.Background(), s, tableName, key)
+}
 
-	res, err := run_transaction(s, pdu)
+// DeleteContext is like Delete but returns ctx.Err() as soon as ctx is done.
+func DeleteContext(ctx context.Context, s Session, tableName string, key map[string]interface{}) (interface{}, error) {
+	pdu := deletePdu(tableName, key)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Read a range of keys from pundun table.
 // Limit the amount of keys read by limit arg.
 func ReadRange(s Session, tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
+	return ReadRangeContext(context.Background(), s, tableName, skey, ekey, limit)
+}
+
+// ReadRangeContext is like ReadRange but returns ctx.Err() as soon as ctx is done.
+func ReadRangeContext(ctx context.Context, s Session, tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
 	skeyFields := fixFields(skey)
 	ekeyFields := fixFields(ekey)
 	readRange := &apollo.ReadRange{
@@ -408,7 +460,7 @@ func ReadRange(s Session, tableName string, skey, ekey map[string]interface{}, l
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return KVL{}, err
 	}
@@ -417,6 +469,11 @@ func ReadRange(s Session, tableName string, skey, ekey map[string]interface{}, l
 
 // Read a range of N number of Keys starting from a key.
 func ReadRangeN(s Session, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeNContext(context.Background(), s, tableName, skey, n)
+}
+
+// ReadRangeNContext is like ReadRangeN but returns ctx.Err() as soon as ctx is done.
+func ReadRangeNContext(ctx context.Context, s Session, tableName string, skey map[string]interface{}, n int) (KVL, error) {
 	skeyFields := fixFields(skey)
 	readRangeN := &apollo.ReadRangeN{
 		TableName: *proto.String(tableName),
@@ -432,7 +489,7 @@ func ReadRangeN(s Session, tableName string, skey map[string]interface{}, n int)
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return KVL{}, err
 	}
@@ -441,6 +498,11 @@ func ReadRangeN(s Session, tableName string, skey map[string]interface{}, n int)
 
 // Read a range of N number of Keys starting from a key for Time Series table.
 func ReadRangeNTs(s Session, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeNTsContext(context.Background(), s, tableName, skey, n)
+}
+
+// ReadRangeNTsContext is like ReadRangeNTs but returns ctx.Err() as soon as ctx is done.
+func ReadRangeNTsContext(ctx context.Context, s Session, tableName string, skey map[string]interface{}, n int) (KVL, error) {
 	skeyFields := fixFields(skey)
 	readRangeNTs := &apollo.ReadRangeNTs{
 		TableName: *proto.String(tableName),
@@ -456,7 +518,7 @@ func ReadRangeNTs(s Session, tableName string, skey map[string]interface{}, n in
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return KVL{}, err
 	}
@@ -465,6 +527,11 @@ func ReadRangeNTs(s Session, tableName string, skey map[string]interface{}, n in
 
 // Read the first key on a pundun table and get an iterator.
 func First(s Session, tableName string) (Iterator, error) {
+	return FirstContext(context.Background(), s, tableName)
+}
+
+// FirstContext is like First but returns ctx.Err() as soon as ctx is done.
+func FirstContext(ctx context.Context, s Session, tableName string) (Iterator, error) {
 	first := &apollo.First{
 		TableName: *proto.String(tableName),
 	}
@@ -477,9 +544,9 @@ func First(s Session, tableName string) (Iterator, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
-	    return Iterator{}, err
+	    return Iterator{}, iteratorError(err)
 	}
 	it := res.(Iterator)
 	return it, nil
@@ -487,6 +554,11 @@ func First(s Session, tableName string) (Iterator, error) {
 
 // Read the last key on a pundun table and get an iterator.
 func Last(s Session, tableName string) (Iterator, error) {
+	return LastContext(context.Background(), s, tableName)
+}
+
+// LastContext is like Last but returns ctx.Err() as soon as ctx is done.
+func LastContext(ctx context.Context, s Session, tableName string) (Iterator, error) {
 	last := &apollo.Last{
 		TableName: *proto.String(tableName),
 	}
@@ -499,9 +571,9 @@ func Last(s Session, tableName string) (Iterator, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
-	    return Iterator{}, err
+	    return Iterator{}, iteratorError(err)
 	}
 	it := res.(Iterator)
 	return it, nil
@@ -509,6 +581,11 @@ func Last(s Session, tableName string) (Iterator, error) {
 
 // Seek a key on pundun table ang get an iterator.
 func Seek(s Session, tableName string, key map[string]interface{}) (Iterator, error) {
+	return SeekContext(context.Background(), s, tableName, key)
+}
+
+// SeekContext is like Seek but returns ctx.Err() as soon as ctx is done.
+func SeekContext(ctx context.Context, s Session, tableName string, key map[string]interface{}) (Iterator, error) {
 	keyFields := fixFields(key)
 	seek := &apollo.Seek{
 		TableName: *proto.String(tableName),
@@ -523,9 +600,9 @@ func Seek(s Session, tableName string, key map[string]interface{}) (Iterator, er
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
-	    return Iterator{}, err
+	    return Iterator{}, iteratorError(err)
 	}
 	it := res.(Iterator)
 	return it, nil
@@ -533,6 +610,11 @@ func Seek(s Session, tableName string, key map[string]interface{}) (Iterator, er
 
 // Get the next key after the position of given iterator.
 func Next(s Session, it []byte) (KVP, error) {
+	return NextContext(context.Background(), s, it)
+}
+
+// NextContext is like Next but returns ctx.Err() as soon as ctx is done.
+func NextContext(ctx context.Context, s Session, it []byte) (KVP, error) {
 	next := &apollo.Next{
 		It: it,
 	}
@@ -545,16 +627,17 @@ func Next(s Session, it []byte) (KVP, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
-	if err != nil {
-	    return KVP{}, err
-	}
-	kvp := res.(KVP)
-	return kvp, nil
+	res, err := iteratorStep(ctx, s, pdu)
+	return res.Kvp, err
 }
 
 // Get the previous key before the position of given iterator.
 func Prev(s Session, it []byte) (interface{}, error) {
+	return PrevContext(context.Background(), s, it)
+}
+
+// PrevContext is like Prev but returns ctx.Err() as soon as ctx is done.
+func PrevContext(ctx context.Context, s Session, it []byte) (interface{}, error) {
 	prev := &apollo.Prev{
 		It: it,
 	}
@@ -567,12 +650,34 @@ func Prev(s Session, it []byte) (interface{}, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
-	return res, err
+	res, err := run_transaction(ctx, s, pdu)
+	return res, iteratorError(err)
+}
+
+// iteratorStep runs an iterator procedure. The node answers with either
+// the key columns pair alone or together with an updated iterator; It
+// is nil in the first case.
+func iteratorStep(ctx context.Context, s Session, pdu *apollo.ApolloPdu) (Iterator, error) {
+	res, err := run_transaction(ctx, s, pdu)
+	if err != nil {
+		return Iterator{}, iteratorError(err)
+	}
+	switch r := res.(type) {
+	case Iterator:
+		return r, nil
+	case KVP:
+		return Iterator{Kvp: r}, nil
+	}
+	return Iterator{}, errors.New("invalid response")
 }
 
 // Make the given column(s) indexed on pundun table.
 func AddIndex(s Session, tableName string, configList []IndexConfig) (interface{}, error) {
+	return AddIndexContext(context.Background(), s, tableName, configList)
+}
+
+// AddIndexContext is like AddIndex but returns ctx.Err() as soon as ctx is done.
+func AddIndexContext(ctx context.Context, s Session, tableName string, configList []IndexConfig) (interface{}, error) {
 	indexConfig := fixIndexConfigList(configList)
 
 	addIndex := &apollo.AddIndex{
@@ -588,7 +693,7 @@ func AddIndex(s Session, tableName string, configList []IndexConfig) (interface{
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
@@ -596,6 +701,12 @@ func AddIndex(s Session, tableName string, configList []IndexConfig) (interface{
 // remove previously indexed terms on those columns.
 func RemoveIndex(s Session, tableName string,
 	columns []string) (interface{}, error) {
+	return RemoveIndexContext(context.Background(), s, tableName, columns)
+}
+
+// RemoveIndexContext is like RemoveIndex but returns ctx.Err() as soon as ctx is done.
+func RemoveIndexContext(ctx context.Context, s Session, tableName string,
+	columns []string) (interface{}, error) {
 
 	removeIndex := &apollo.RemoveIndex{
 		TableName: *proto.String(tableName),
@@ -610,12 +721,17 @@ func RemoveIndex(s Session, tableName string,
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // Get the keys by terms that are indexed on given pundun table and it's column.
 func IndexRead(s Session, tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
+	return IndexReadContext(context.Background(), s, tableName, columnName, term, pf)
+}
+
+// IndexReadContext is like IndexRead but returns ctx.Err() as soon as ctx is done.
+func IndexReadContext(ctx context.Context, s Session, tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
 	var postingFilter *apollo.PostingFilter
 	postingFilter = fixPostingFilter(pf)
 	indexRead := &apollo.IndexRead{
@@ -632,12 +748,17 @@ func IndexRead(s Session, tableName string, columnName string, term string, pf P
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	return res, err
 }
 
 // List the existing tables on Pundun
 func ListTables(s Session) ([]string, error) {
+	return ListTablesContext(context.Background(), s)
+}
+
+// ListTablesContext is like ListTables but returns ctx.Err() as soon as ctx is done.
+func ListTablesContext(ctx context.Context, s Session) ([]string, error) {
 	listTables := &apollo.ListTables{}
 	procedure := &apollo.ApolloPdu_ListTables{
 		ListTables: listTables,
@@ -647,29 +768,141 @@ func ListTables(s Session) ([]string, error) {
 		Procedure: procedure,
 	}
 
-	res, err := run_transaction(s, pdu)
+	res, err := run_transaction(ctx, s, pdu)
 	if err != nil {
 	    return []string{}, err
 	}
 	return res.([]string), nil
 }
 
-func run_transaction(s Session, pdu *apollo.ApolloPdu) (interface{}, error) {
-	tid := GetTid(s)
-	pdu = make_pdu(pdu, tid)
-	pduBin, err := proto.Marshal(pdu)
+func run_transaction(ctx context.Context, s Session, pdu *apollo.ApolloPdu) (interface{}, error) {
+	r, err := run_raw_transaction(ctx, s, pdu)
 	if err != nil {
-		log.Println("marshaling error: ", err)
 		return nil, err
 	}
+	return getResult(r), nil
+}
 
-	recv, err := send(s, pduBin)
+// run_raw_transaction is like run_transaction but returns the response
+// as received, before its values are converted.
+func run_raw_transaction(ctx context.Context, s Session, pdu *apollo.ApolloPdu) (*apollo.Response, error) {
+	pduBin, err := marshal_pdu(s, pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	recv, err := send(ctx, s, pduBin)
+	for retry := 0; IsRetriable(err) && isIdempotent(pdu) && retry < maxReplays; retry++ {
+		recv, err = send(ctx, s, pduBin)
+	}
 
 	if err != nil {
 		log.Println("error: ", err)
+		return nil, err
+	}
+	return rawResponse(recv)
+}
+
+// isIdempotent reports whether the procedure in pdu can be replayed
+// after a connection loss without changing its outcome.
+func isIdempotent(pdu *apollo.ApolloPdu) bool {
+	switch pdu.GetProcedure().(type) {
+	case *apollo.ApolloPdu_Read,
+		*apollo.ApolloPdu_ReadRange,
+		*apollo.ApolloPdu_ReadRangeN,
+		*apollo.ApolloPdu_ReadRangeNTs,
+		*apollo.ApolloPdu_TableInfo,
+		*apollo.ApolloPdu_ListTables,
+		*apollo.ApolloPdu_IndexRead:
+		return true
+	default:
+		return false
 	}
-	res, err := waitForResponse(recv)
-	return res, err
+}
+
+func readPdu(tableName string, key map[string]interface{}) *apollo.ApolloPdu {
+	keyFields := fixFields(key)
+	read := &apollo.Read{
+		TableName: *proto.String(tableName),
+		Key:       keyFields,
+	}
+
+	procedure := &apollo.ApolloPdu_Read{
+		Read: read,
+	}
+
+	pdu := &apollo.ApolloPdu{
+		Procedure: procedure,
+	}
+	return pdu
+}
+
+func writePdu(tableName string, key, columns map[string]interface{}) *apollo.ApolloPdu {
+	return writeFieldsPdu(tableName, fixFields(key), fixFields(columns))
+}
+
+func writeFieldsPdu(tableName string, keyFields, columnFields []*apollo.Field) *apollo.ApolloPdu {
+	write := &apollo.Write{
+		TableName: *proto.String(tableName),
+		Key:       keyFields,
+		Columns:   columnFields,
+	}
+
+	procedure := &apollo.ApolloPdu_Write{
+		Write: write,
+	}
+
+	pdu := &apollo.ApolloPdu{
+		Procedure: procedure,
+	}
+	return pdu
+}
+
+func updatePdu(tableName string, key map[string]interface{}, upOps []UpdateOperation) *apollo.ApolloPdu {
+	keyFields := fixFields(key)
+	updateOperations := fixUpdateOperations(upOps)
+	update := &apollo.Update{
+		TableName:       *proto.String(tableName),
+		Key:             keyFields,
+		UpdateOperation: updateOperations,
+	}
+
+	procedure := &apollo.ApolloPdu_Update{
+		Update: update,
+	}
+
+	pdu := &apollo.ApolloPdu{
+		Procedure: procedure,
+	}
+	return pdu
+}
+
+func deletePdu(tableName string, key map[string]interface{}) *apollo.ApolloPdu {
+	keyFields := fixFields(key)
+	delete := &apollo.Delete{
+		TableName: *proto.String(tableName),
+		Key:       keyFields,
+	}
+
+	procedure := &apollo.ApolloPdu_Delete{
+		Delete: delete,
+	}
+
+	pdu := &apollo.ApolloPdu{
+		Procedure: procedure,
+	}
+	return pdu
+}
+
+func marshal_pdu(s Session, pdu *apollo.ApolloPdu) ([]byte, error) {
+	tid := GetTid(s)
+	pdu = make_pdu(pdu, tid)
+	pduBin, err := proto.Marshal(pdu)
+	if err != nil {
+		log.Println("marshaling error: ", err)
+		return nil, err
+	}
+	return pduBin, nil
 }
 
 func make_pdu(pdu *apollo.ApolloPdu, tid uint32) *apollo.ApolloPdu {
@@ -682,12 +915,19 @@ func make_pdu(pdu *apollo.ApolloPdu, tid uint32) *apollo.ApolloPdu {
 	return pdu
 }
 
-func send(s Session, data []byte) ([]byte, error) {
-	response := SendMsg(s, data)
-	return response, nil
+func send(ctx context.Context, s Session, data []byte) ([]byte, error) {
+	return SendMsgContext(ctx, s, data)
 }
 
 func waitForResponse(recv []byte) (interface{}, error) {
+	r, err := rawResponse(recv)
+	if err != nil {
+		return nil, err
+	}
+	return getResult(r), nil
+}
+
+func rawResponse(recv []byte) (*apollo.Response, error) {
 	recvPdu := &apollo.ApolloPdu{}
 	err := proto.Unmarshal(recv, recvPdu)
 
@@ -703,8 +943,7 @@ func waitForResponse(recv []byte) (interface{}, error) {
 		return nil, pErr
 	}
 	if r != nil {
-		result := getResult(r)
-		return result, nil
+		return r, nil
 	}
 
 	return nil, errors.New("invalid response")
@@ -747,33 +986,18 @@ func getResult(r *apollo.Response) interface{} {
 	}
 }
 
-func appendstring(a string, b string) string {
-	if a == "" {
-		return b
-	}
-	return a + " " + b
-}
 func getError(e *apollo.Error) error {
-	var m string
-	t := e.GetTransport()
-	if t != "" {
-		m = appendstring(m, "transport: " + t)
+	if t := e.GetTransport(); t != "" {
+		return &TransportError{t}
 	}
-	p := e.GetProtocol()
-	if p != "" {
-		m = appendstring(m, "protocol: " + p)
+	if p := e.GetProtocol(); p != "" {
+		return &ProtocolError{p}
 	}
-	s := e.GetSystem()
-	if s != "" {
-		m = appendstring(m, "system: " + s)
+	if s := e.GetSystem(); s != "" {
+		return &SystemError{s}
 	}
-	o := e.GetMisc()
-	if o != "" {
-		m = appendstring(m, "misc: " + o)
-	}
-
-	if m != "" {
-		return errors.New(m)
+	if o := e.GetMisc(); o != "" {
+		return &MiscError{o}
 	}
 	return nil
 }
@@ -872,21 +1096,191 @@ func formatValue(v *apollo.Value) interface{} {
 	return value
 }
 
-func fixOptions(options map[string]interface{}) []*apollo.TableOption {
-	tableOptions := make([]*apollo.TableOption, 0)
+// ParseTableOptions converts options given by name into TableOptions.
+// Known names are "type", "data_model", "wrapper", "tda",
+// "hashing_method", "comparator", "time_series", "distributed",
+// "num_of_shards", "replication_factor", "hash_exclude" and "ttl".
+// Enumerations may be given by constant or by name, e.g. Rocksdb or
+// "rocksdb". Unknown names and values of the wrong type are an error.
+func ParseTableOptions(options map[string]interface{}) (TableOptions, error) {
+	var opts TableOptions
 	for k, v := range options {
-		tableOptions = fixOption(k, v, tableOptions)
+		if err := parseOption(&opts, k, v); err != nil {
+			return TableOptions{}, err
+		}
 	}
-	return tableOptions
+	return opts, nil
 }
 
-func fixOption(k string, v interface{}, opts []*apollo.TableOption) []*apollo.TableOption {
-	var opt *apollo.TableOption
+var tableTypes = map[string]int{
+	"leveldb":             Leveldb,
+	"mem_leveldb":         MemLeveldb,
+	"leveldb_wrapped":     LeveldbWrapped,
+	"mem_leveldb_wrapped": MemLeveldbWrapped,
+	"leveldb_tda":         LeveldbTda,
+	"mem_leveldb_tda":     MemLeveldbTda,
+	"rocksdb":             Rocksdb,
+}
 
+var hashingMethods = map[string]int{
+	"virtual_nodes": VirtualNodes,
+	"consistent":    Consistent,
+	"uniform":       Uniform,
+	"rendezvous":    Rendezvous,
+}
+
+func parseOption(opts *TableOptions, k string, v interface{}) error {
+	invalid := func() error {
+		return fmt.Errorf("%w: %v: unexpected value %#v", ErrInvalidOption, k, v)
+	}
 	switch k {
 	case "type":
-		tableType := apollo.Type_ROCKSDB
+		t, ok := enumOption(v, tableTypes, Rocksdb)
+		if !ok {
+			return invalid()
+		}
+		opts.Type = &t
+	case "data_model":
+		switch v {
+		case "kv", "array", "map":
+			opts.DataModel = v.(string)
+		default:
+			return invalid()
+		}
+	case "wrapper":
+		switch w := v.(type) {
+		case Wrapper:
+			opts.Wrapper = &w
+		case *Wrapper:
+			opts.Wrapper = w
+		default:
+			return invalid()
+		}
+	case "tda":
+		switch t := v.(type) {
+		case Tda:
+			opts.Tda = &t
+		case *Tda:
+			opts.Tda = t
+		default:
+			return invalid()
+		}
+	case "hashing_method":
+		hm, ok := enumOption(v, hashingMethods, Rendezvous)
+		if !ok {
+			return invalid()
+		}
+		opts.HashingMethod = &hm
+	case "comparator":
 		switch v {
+		case "ascending", "descending":
+			opts.Comparator = v.(string)
+		default:
+			return invalid()
+		}
+	case "time_series":
+		b, ok := v.(bool)
+		if !ok {
+			return invalid()
+		}
+		opts.TimeSeries = &b
+	case "distributed":
+		b, ok := v.(bool)
+		if !ok {
+			return invalid()
+		}
+		opts.Distributed = &b
+	case "num_of_shards":
+		n, ok := uintOption(v)
+		if !ok {
+			return invalid()
+		}
+		opts.NumOfShards = n
+	case "replication_factor":
+		n, ok := uintOption(v)
+		if !ok {
+			return invalid()
+		}
+		opts.ReplicationFactor = n
+	case "ttl":
+		n, ok := uintOption(v)
+		if !ok {
+			return invalid()
+		}
+		opts.TTL = n
+	case "hash_exclude":
+		switch l := v.(type) {
+		case []string:
+			opts.HashExclude = l
+		case []interface{}:
+			fields := make([]string, len(l))
+			for i, e := range l {
+				f, ok := e.(string)
+				if !ok {
+					return invalid()
+				}
+				fields[i] = f
+			}
+			opts.HashExclude = fields
+		default:
+			return invalid()
+		}
+	default:
+		return fmt.Errorf("%w: unknown option %v", ErrInvalidOption, k)
+	}
+	return nil
+}
+
+// enumOption accepts an enum value given by constant up to max or by name.
+func enumOption(v interface{}, names map[string]int, max int) (int, bool) {
+	if name, ok := v.(string); ok {
+		e, ok := names[name]
+		return e, ok
+	}
+	n, ok := uintOption(v)
+	if !ok || n > uint32(max) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// uintOption accepts any integral number that fits in an uint32,
+// including float64 values as decoded from JSON.
+func uintOption(v interface{}) (uint32, bool) {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint32:
+		n = int64(v)
+	case uint:
+		n = int64(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, false
+		}
+		n = int64(v)
+	default:
+		return 0, false
+	}
+	if n < 0 || n > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+func fixTableOptions(o TableOptions) ([]*apollo.TableOption, error) {
+	opts := make([]*apollo.TableOption, 0)
+	add := func(opt *apollo.TableOption) {
+		opts = append(opts, opt)
+	}
+	if o.Type != nil {
+		tableType := apollo.Type_ROCKSDB
+		switch *o.Type {
 		case Leveldb:
 			tableType = apollo.Type_LEVELDB
 		case MemLeveldb:
@@ -899,28 +1293,36 @@ func fixOption(k string, v interface{}, opts []*apollo.TableOption) []*apollo.Ta
 			tableType = apollo.Type_LEVELDBTDA
 		case MemLeveldbTda:
 			tableType = apollo.Type_MEMLEVELDBTDA
+		case Rocksdb:
 		default:
+			return nil, fmt.Errorf("%w: type: unknown value %v", ErrInvalidOption, *o.Type)
 		}
-		opt = &apollo.TableOption{Opt: &apollo.TableOption_Type{tableType}}
-	case "data_model":
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Type{tableType}})
+	}
+	if o.DataModel != "" {
 		dataModel := apollo.DataModel_ARRAY
-		switch v {
+		switch o.DataModel {
 		case "kv":
 			dataModel = apollo.DataModel_KV
 		case "map":
 			dataModel = apollo.DataModel_MAP
+		case "array":
 		default:
+			return nil, fmt.Errorf("%w: data_model: unknown value %v", ErrInvalidOption, o.DataModel)
 		}
-		opt = &apollo.TableOption{Opt: &apollo.TableOption_DataModel{dataModel}}
-	case "wrapper":
-		wrapper := fixWrapper(v.(Wrapper))
-		opt = &apollo.TableOption{Opt: &apollo.TableOption_Wrapper{wrapper}}
-	case "tda":
-		tda := fixTda(v.(Tda))
-		opt = &apollo.TableOption{Opt: &apollo.TableOption_Tda{tda}}
-	case "hashing_method":
+		add(&apollo.TableOption{Opt: &apollo.TableOption_DataModel{dataModel}})
+	}
+	if o.Wrapper != nil {
+		wrapper := fixWrapper(*o.Wrapper)
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Wrapper{wrapper}})
+	}
+	if o.Tda != nil {
+		tda := fixTda(*o.Tda)
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Tda{tda}})
+	}
+	if o.HashingMethod != nil {
 		hm := apollo.HashingMethod_UNIFORM
-		switch v {
+		switch *o.HashingMethod {
 		case VirtualNodes:
 			hm = apollo.HashingMethod_VIRTUALNODES
 		case Consistent:
@@ -930,47 +1332,41 @@ func fixOption(k string, v interface{}, opts []*apollo.TableOption) []*apollo.Ta
 		case Rendezvous:
 			hm = apollo.HashingMethod_RENDEZVOUS
 		default:
+			return nil, fmt.Errorf("%w: hashing_method: unknown value %v", ErrInvalidOption, *o.HashingMethod)
 		}
-		opt = &apollo.TableOption{Opt: &apollo.TableOption_HashingMethod{hm}}
-	case "comparator" :
+		add(&apollo.TableOption{Opt: &apollo.TableOption_HashingMethod{hm}})
+	}
+	if o.Comparator != "" {
 		comp := apollo.Comparator_DESCENDING
-		switch v {
-		case "ascending" :
-		    comp = apollo.Comparator_ASCENDING
+		switch o.Comparator {
+		case "ascending":
+			comp = apollo.Comparator_ASCENDING
+		case "descending":
 		default:
+			return nil, fmt.Errorf("%w: comparator: unknown value %v", ErrInvalidOption, o.Comparator)
 		}
-		    opt = &apollo.TableOption{Opt:
-				&apollo.TableOption_Comparator{comp}}
-	case "distributed" :
-		dist := true
-		switch v {
-		case false :
-			dist = false
-		default :
-		}
-		opt = &apollo.TableOption{Opt:
-				&apollo.TableOption_Distributed{dist}}
-	case "num_of_shards" :
-		switch v := v.(type) {
-		case int:
-		    opt = &apollo.TableOption{Opt:
-				&apollo.TableOption_NumOfShards{uint32(v)}}
-		case uint32:
-		    opt = &apollo.TableOption{Opt:
-				&apollo.TableOption_NumOfShards{v}}
-		default :
-		}
-	default:
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Comparator{comp}})
 	}
-	if opt != nil {
-		len := len(opts) + 1
-		newOpts := make([]*apollo.TableOption, len)
-		copy(newOpts, opts[:])
-		newOpts[len-1] = opt
-		return newOpts
-	} else {
-		return opts
+	if o.TimeSeries != nil {
+		add(&apollo.TableOption{Opt: &apollo.TableOption_TimeSeries{*o.TimeSeries}})
+	}
+	if o.Distributed != nil {
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Distributed{*o.Distributed}})
+	}
+	if o.NumOfShards != 0 {
+		add(&apollo.TableOption{Opt: &apollo.TableOption_NumOfShards{o.NumOfShards}})
+	}
+	if o.ReplicationFactor != 0 {
+		add(&apollo.TableOption{Opt: &apollo.TableOption_ReplicationFactor{o.ReplicationFactor}})
+	}
+	if o.HashExclude != nil {
+		fieldNames := &apollo.FieldNames{FieldNames: o.HashExclude}
+		add(&apollo.TableOption{Opt: &apollo.TableOption_HashExclude{fieldNames}})
+	}
+	if o.TTL != 0 {
+		add(&apollo.TableOption{Opt: &apollo.TableOption_Ttl{o.TTL}})
 	}
+	return opts, nil
 }
 
 func fixTda(t Tda) *apollo.Tda {
@@ -0,0 +1,175 @@
+package pundun
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TableSchema is the definition of a table kept in a backup.
+type TableSchema struct {
+	Name    string
+	Key     []string
+	Options TableOptions
+	Indexes []IndexConfig
+}
+
+// RestoreOptions controls Restore.
+type RestoreOptions struct {
+	// Tables restricts the restore to the tables with these names in the
+	// backup. All tables are restored when empty.
+	Tables []string
+	// Rename gives new names to tables of the backup.
+	Rename map[string]string
+	// SchemaOnly creates the tables without loading their data.
+	SchemaOnly bool
+	// ErrorLog receives a line for each row that could not be written.
+	ErrorLog io.Writer
+}
+
+// Backup writes the schema and data of the given tables, or of every
+// table when none are given, to w as a tar archive. Each table is stored
+// as a "<name>.schema.json" entry holding its TableSchema followed by a
+// "<name>.dump" entry in the Dump format of Export.
+func Backup(ctx context.Context, s Session, w io.Writer, tables []string) error {
+	if len(tables) == 0 {
+		var err error
+		if tables, err = ListTablesContext(ctx, s); err != nil {
+			return err
+		}
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range tables {
+		if err := backupTable(ctx, s, tw, name); err != nil {
+			return fmt.Errorf("backup %s: %w", name, err)
+		}
+	}
+	return tw.Close()
+}
+
+func backupTable(ctx context.Context, s Session, tw *tar.Writer, name string) error {
+	info, err := DescribeTableContext(ctx, s, name, nil)
+	if err != nil {
+		return err
+	}
+	schema := TableSchema{Name: name, Key: info.Key, Options: info.Options()}
+	for _, index := range info.Indexes {
+		schema.Indexes = append(schema.Indexes, index.Config())
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	entry := url.PathEscape(name)
+	now := time.Now()
+	hdr := &tar.Header{Name: entry + ".schema.json", Mode: 0644, Size: int64(len(b)), ModTime: now}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+
+	// The size of a tar entry is needed before its content, so the dump
+	// is spooled to a temporary file.
+	f, err := os.CreateTemp("", "pundun-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := Export(ctx, s, name, f, Dump, ExportOptions{}); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr = &tar.Header{Name: entry + ".dump", Mode: 0644, Size: size, ModTime: now}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Restore recreates the tables of a backup written by Backup, adds their
+// indexes and loads their data. It returns the load stats of each table
+// by restored name. Restoring into an existing table fails with
+// ErrTableExists.
+func Restore(ctx context.Context, s Session, r io.Reader, opts RestoreOptions) (map[string]LoadStats, error) {
+	wanted := make(map[string]bool, len(opts.Tables))
+	for _, name := range opts.Tables {
+		wanted[name] = true
+	}
+	restored := make(map[string]LoadStats)
+	skip := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return restored, nil
+		} else if err != nil {
+			return restored, err
+		}
+		switch {
+		case strings.HasSuffix(hdr.Name, ".schema.json"):
+			var schema TableSchema
+			if err := json.NewDecoder(tr).Decode(&schema); err != nil {
+				return restored, fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			if len(wanted) > 0 && !wanted[schema.Name] {
+				skip[url.PathEscape(schema.Name)] = true
+				continue
+			}
+			name := restoredName(schema.Name, opts)
+			if err := restoreSchema(ctx, s, name, schema); err != nil {
+				return restored, fmt.Errorf("restore %s: %w", name, err)
+			}
+			restored[name] = LoadStats{}
+		case strings.HasSuffix(hdr.Name, ".dump"):
+			entry := strings.TrimSuffix(hdr.Name, ".dump")
+			orig, err := url.PathUnescape(entry)
+			if err != nil || skip[entry] || opts.SchemaOnly {
+				continue
+			}
+			name := restoredName(orig, opts)
+			if _, ok := restored[name]; !ok {
+				return restored, fmt.Errorf("%s: missing schema", hdr.Name)
+			}
+			stats, err := Load(ctx, s, name, tr, Dump, LoadMapping{ErrorLog: opts.ErrorLog})
+			restored[name] = stats
+			if err != nil {
+				return restored, fmt.Errorf("restore %s: %w", name, err)
+			}
+		}
+	}
+}
+
+func restoredName(name string, opts RestoreOptions) string {
+	if n, ok := opts.Rename[name]; ok {
+		return n
+	}
+	return name
+}
+
+func restoreSchema(ctx context.Context, s Session, name string, schema TableSchema) error {
+	if _, err := CreateTableWithOptionsContext(ctx, s, name, schema.Key, schema.Options); err != nil {
+		return err
+	}
+	if len(schema.Indexes) > 0 {
+		if _, err := AddIndexContext(ctx, s, name, schema.Indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package pundun
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// Batch accumulates Write, Delete, Update and Read operations that are
+// sent back to back on a session by Run, without waiting for each
+// response before sending the next request. Operations are sent in the
+// order they were added.
+type Batch struct {
+	// MaxInFlight bounds the number of operations awaiting a response.
+	// Defaults to 1024.
+	MaxInFlight int
+
+	pdus []*apollo.ApolloPdu
+}
+
+// BatchResult is the outcome of one operation of a Batch. Result holds
+// what the corresponding procedure call would return.
+type BatchResult struct {
+	Result interface{}
+	Err    error
+}
+
+// NewBatch returns an empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.pdus)
+}
+
+// Write adds a write of key and columns to a pundun table.
+func (b *Batch) Write(tableName string, key, columns map[string]interface{}) *Batch {
+	b.pdus = append(b.pdus, writePdu(tableName, key, columns))
+	return b
+}
+
+// writeFields adds a write of fields already converted to apollo values.
+func (b *Batch) writeFields(tableName string, key, columns []*apollo.Field) *Batch {
+	b.pdus = append(b.pdus, writeFieldsPdu(tableName, key, columns))
+	return b
+}
+
+// Delete adds a delete of key from a pundun table.
+func (b *Batch) Delete(tableName string, key map[string]interface{}) *Batch {
+	b.pdus = append(b.pdus, deletePdu(tableName, key))
+	return b
+}
+
+// Update adds an update of a key's columns on a pundun table.
+func (b *Batch) Update(tableName string, key map[string]interface{}, upOps []UpdateOperation) *Batch {
+	b.pdus = append(b.pdus, updatePdu(tableName, key, upOps))
+	return b
+}
+
+// Read adds a read of key from a pundun table.
+func (b *Batch) Read(tableName string, key map[string]interface{}) *Batch {
+	b.pdus = append(b.pdus, readPdu(tableName, key))
+	return b
+}
+
+// Run sends the operations of the batch on s and returns their results
+// in the order the operations were added.
+func (b *Batch) Run(s Session) []BatchResult {
+	return b.RunContext(context.Background(), s)
+}
+
+// RunContext is like Run. Operations not completed when ctx is done
+// fail with ctx.Err().
+func (b *Batch) RunContext(ctx context.Context, s Session) []BatchResult {
+	window := b.MaxInFlight
+	if window <= 0 {
+		window = 1024
+	}
+	results := make([]BatchResult, len(b.pdus))
+	slots := make(chan struct{}, window)
+	var wg sync.WaitGroup
+	for i, pdu := range b.pdus {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		pduBin, err := marshal_pdu(s, pdu)
+		if err == nil {
+			var c caller
+			if c, err = enqueue(ctx, s, pduBin); err == nil {
+				wg.Add(1)
+				go func(i int, pdu *apollo.ApolloPdu, pduBin []byte, c caller) {
+					defer wg.Done()
+					defer func() { <-slots }()
+					recv, err := awaitReply(ctx, s, c)
+					for retry := 0; IsRetriable(err) && isIdempotent(pdu) && retry < maxReplays; retry++ {
+						recv, err = send(ctx, s, pduBin)
+					}
+					if err == nil {
+						results[i].Result, err = waitForResponse(recv)
+					}
+					results[i].Err = err
+				}(i, pdu, pduBin, c)
+				continue
+			}
+		}
+		results[i].Err = err
+		<-slots
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,288 @@
+package pundun
+
+import "context"
+
+// Client is implemented by Session, Pool and by the fakes in package
+// pundunmock, so that application code can be written against either.
+// Its methods mirror the package-level procedures with the session
+// argument dropped.
+type Client interface {
+	// Table administration
+	CreateTable(tableName string, key []string, options map[string]interface{}) (interface{}, error)
+	CreateTableContext(ctx context.Context, tableName string, key []string, options map[string]interface{}) (interface{}, error)
+	CreateTableWithOptions(tableName string, key []string, opts TableOptions) (interface{}, error)
+	CreateTableWithOptionsContext(ctx context.Context, tableName string, key []string, opts TableOptions) (interface{}, error)
+	DeleteTable(tableName string) (interface{}, error)
+	DeleteTableContext(ctx context.Context, tableName string) (interface{}, error)
+	OpenTable(tableName string) (interface{}, error)
+	OpenTableContext(ctx context.Context, tableName string) (interface{}, error)
+	CloseTable(tableName string) (interface{}, error)
+	CloseTableContext(ctx context.Context, tableName string) (interface{}, error)
+	TableInfo(tableName string, attrs []string) (interface{}, error)
+	TableInfoContext(ctx context.Context, tableName string, attrs []string) (interface{}, error)
+	ListTables() ([]string, error)
+	ListTablesContext(ctx context.Context) ([]string, error)
+
+	// Key value access
+	Read(tableName string, key map[string]interface{}) (map[string]interface{}, error)
+	ReadContext(ctx context.Context, tableName string, key map[string]interface{}) (map[string]interface{}, error)
+	Write(tableName string, key, columns map[string]interface{}) (interface{}, error)
+	WriteContext(ctx context.Context, tableName string, key, columns map[string]interface{}) (interface{}, error)
+	Update(tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error)
+	UpdateContext(ctx context.Context, tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error)
+	Delete(tableName string, key map[string]interface{}) (interface{}, error)
+	DeleteContext(ctx context.Context, tableName string, key map[string]interface{}) (interface{}, error)
+
+	// Ranges
+	ReadRange(tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error)
+	ReadRangeContext(ctx context.Context, tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error)
+	ReadRangeN(tableName string, skey map[string]interface{}, n int) (KVL, error)
+	ReadRangeNContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error)
+	ReadRangeNTs(tableName string, skey map[string]interface{}, n int) (KVL, error)
+	ReadRangeNTsContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error)
+
+	// Iterators
+	First(tableName string) (Iterator, error)
+	FirstContext(ctx context.Context, tableName string) (Iterator, error)
+	Last(tableName string) (Iterator, error)
+	LastContext(ctx context.Context, tableName string) (Iterator, error)
+	Seek(tableName string, key map[string]interface{}) (Iterator, error)
+	SeekContext(ctx context.Context, tableName string, key map[string]interface{}) (Iterator, error)
+	Next(it []byte) (KVP, error)
+	NextContext(ctx context.Context, it []byte) (KVP, error)
+	Prev(it []byte) (interface{}, error)
+	PrevContext(ctx context.Context, it []byte) (interface{}, error)
+
+	// Indexes
+	AddIndex(tableName string, configList []IndexConfig) (interface{}, error)
+	AddIndexContext(ctx context.Context, tableName string, configList []IndexConfig) (interface{}, error)
+	RemoveIndex(tableName string, columns []string) (interface{}, error)
+	RemoveIndexContext(ctx context.Context, tableName string, columns []string) (interface{}, error)
+	IndexRead(tableName string, columnName string, term string, pf PostingFilter) (interface{}, error)
+	IndexReadContext(ctx context.Context, tableName string, columnName string, term string, pf PostingFilter) (interface{}, error)
+}
+
+var (
+	_ Client = Session{}
+	_ Client = (*Pool)(nil)
+)
+
+// CreateTable calls the CreateTable procedure on the session.
+func (s Session) CreateTable(tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	return CreateTable(s, tableName, key, options)
+}
+
+// CreateTableContext calls CreateTableContext on the session.
+func (s Session) CreateTableContext(ctx context.Context, tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	return CreateTableContext(ctx, s, tableName, key, options)
+}
+
+// CreateTableWithOptions calls the CreateTableWithOptions procedure on the session.
+func (s Session) CreateTableWithOptions(tableName string, key []string, opts TableOptions) (interface{}, error) {
+	return CreateTableWithOptions(s, tableName, key, opts)
+}
+
+// CreateTableWithOptionsContext calls CreateTableWithOptionsContext on the session.
+func (s Session) CreateTableWithOptionsContext(ctx context.Context, tableName string, key []string, opts TableOptions) (interface{}, error) {
+	return CreateTableWithOptionsContext(ctx, s, tableName, key, opts)
+}
+
+// DeleteTable calls the DeleteTable procedure on the session.
+func (s Session) DeleteTable(tableName string) (interface{}, error) {
+	return DeleteTable(s, tableName)
+}
+
+// DeleteTableContext calls DeleteTableContext on the session.
+func (s Session) DeleteTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	return DeleteTableContext(ctx, s, tableName)
+}
+
+// OpenTable calls the OpenTable procedure on the session.
+func (s Session) OpenTable(tableName string) (interface{}, error) {
+	return OpenTable(s, tableName)
+}
+
+// OpenTableContext calls OpenTableContext on the session.
+func (s Session) OpenTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	return OpenTableContext(ctx, s, tableName)
+}
+
+// CloseTable calls the CloseTable procedure on the session.
+func (s Session) CloseTable(tableName string) (interface{}, error) {
+	return CloseTable(s, tableName)
+}
+
+// CloseTableContext calls CloseTableContext on the session.
+func (s Session) CloseTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	return CloseTableContext(ctx, s, tableName)
+}
+
+// TableInfo calls the TableInfo procedure on the session.
+func (s Session) TableInfo(tableName string, attrs []string) (interface{}, error) {
+	return TableInfo(s, tableName, attrs)
+}
+
+// TableInfoContext calls TableInfoContext on the session.
+func (s Session) TableInfoContext(ctx context.Context, tableName string, attrs []string) (interface{}, error) {
+	return TableInfoContext(ctx, s, tableName, attrs)
+}
+
+// ListTables calls the ListTables procedure on the session.
+func (s Session) ListTables() ([]string, error) {
+	return ListTables(s)
+}
+
+// ListTablesContext calls ListTablesContext on the session.
+func (s Session) ListTablesContext(ctx context.Context) ([]string, error) {
+	return ListTablesContext(ctx, s)
+}
+
+// Read calls the Read procedure on the session.
+func (s Session) Read(tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	return Read(s, tableName, key)
+}
+
+// ReadContext calls ReadContext on the session.
+func (s Session) ReadContext(ctx context.Context, tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	return ReadContext(ctx, s, tableName, key)
+}
+
+// Write calls the Write procedure on the session.
+func (s Session) Write(tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	return Write(s, tableName, key, columns)
+}
+
+// WriteContext calls WriteContext on the session.
+func (s Session) WriteContext(ctx context.Context, tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	return WriteContext(ctx, s, tableName, key, columns)
+}
+
+// Update calls the Update procedure on the session.
+func (s Session) Update(tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
+	return Update(s, tableName, key, upOps)
+}
+
+// UpdateContext calls UpdateContext on the session.
+func (s Session) UpdateContext(ctx context.Context, tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
+	return UpdateContext(ctx, s, tableName, key, upOps)
+}
+
+// Delete calls the Delete procedure on the session.
+func (s Session) Delete(tableName string, key map[string]interface{}) (interface{}, error) {
+	return Delete(s, tableName, key)
+}
+
+// DeleteContext calls DeleteContext on the session.
+func (s Session) DeleteContext(ctx context.Context, tableName string, key map[string]interface{}) (interface{}, error) {
+	return DeleteContext(ctx, s, tableName, key)
+}
+
+// ReadRange calls the ReadRange procedure on the session.
+func (s Session) ReadRange(tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
+	return ReadRange(s, tableName, skey, ekey, limit)
+}
+
+// ReadRangeContext calls ReadRangeContext on the session.
+func (s Session) ReadRangeContext(ctx context.Context, tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
+	return ReadRangeContext(ctx, s, tableName, skey, ekey, limit)
+}
+
+// ReadRangeN calls the ReadRangeN procedure on the session.
+func (s Session) ReadRangeN(tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeN(s, tableName, skey, n)
+}
+
+// ReadRangeNContext calls ReadRangeNContext on the session.
+func (s Session) ReadRangeNContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeNContext(ctx, s, tableName, skey, n)
+}
+
+// ReadRangeNTs calls the ReadRangeNTs procedure on the session.
+func (s Session) ReadRangeNTs(tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeNTs(s, tableName, skey, n)
+}
+
+// ReadRangeNTsContext calls ReadRangeNTsContext on the session.
+func (s Session) ReadRangeNTsContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return ReadRangeNTsContext(ctx, s, tableName, skey, n)
+}
+
+// First calls the First procedure on the session.
+func (s Session) First(tableName string) (Iterator, error) {
+	return First(s, tableName)
+}
+
+// FirstContext calls FirstContext on the session.
+func (s Session) FirstContext(ctx context.Context, tableName string) (Iterator, error) {
+	return FirstContext(ctx, s, tableName)
+}
+
+// Last calls the Last procedure on the session.
+func (s Session) Last(tableName string) (Iterator, error) {
+	return Last(s, tableName)
+}
+
+// LastContext calls LastContext on the session.
+func (s Session) LastContext(ctx context.Context, tableName string) (Iterator, error) {
+	return LastContext(ctx, s, tableName)
+}
+
+// Seek calls the Seek procedure on the session.
+func (s Session) Seek(tableName string, key map[string]interface{}) (Iterator, error) {
+	return Seek(s, tableName, key)
+}
+
+// SeekContext calls SeekContext on the session.
+func (s Session) SeekContext(ctx context.Context, tableName string, key map[string]interface{}) (Iterator, error) {
+	return SeekContext(ctx, s, tableName, key)
+}
+
+// Next calls the Next procedure on the session.
+func (s Session) Next(it []byte) (KVP, error) {
+	return Next(s, it)
+}
+
+// NextContext calls NextContext on the session.
+func (s Session) NextContext(ctx context.Context, it []byte) (KVP, error) {
+	return NextContext(ctx, s, it)
+}
+
+// Prev calls the Prev procedure on the session.
+func (s Session) Prev(it []byte) (interface{}, error) {
+	return Prev(s, it)
+}
+
+// PrevContext calls PrevContext on the session.
+func (s Session) PrevContext(ctx context.Context, it []byte) (interface{}, error) {
+	return PrevContext(ctx, s, it)
+}
+
+// AddIndex calls the AddIndex procedure on the session.
+func (s Session) AddIndex(tableName string, configList []IndexConfig) (interface{}, error) {
+	return AddIndex(s, tableName, configList)
+}
+
+// AddIndexContext calls AddIndexContext on the session.
+func (s Session) AddIndexContext(ctx context.Context, tableName string, configList []IndexConfig) (interface{}, error) {
+	return AddIndexContext(ctx, s, tableName, configList)
+}
+
+// RemoveIndex calls the RemoveIndex procedure on the session.
+func (s Session) RemoveIndex(tableName string, columns []string) (interface{}, error) {
+	return RemoveIndex(s, tableName, columns)
+}
+
+// RemoveIndexContext calls RemoveIndexContext on the session.
+func (s Session) RemoveIndexContext(ctx context.Context, tableName string, columns []string) (interface{}, error) {
+	return RemoveIndexContext(ctx, s, tableName, columns)
+}
+
+// IndexRead calls the IndexRead procedure on the session.
+func (s Session) IndexRead(tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
+	return IndexRead(s, tableName, columnName, term, pf)
+}
+
+// IndexReadContext calls IndexReadContext on the session.
+func (s Session) IndexReadContext(ctx context.Context, tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
+	return IndexReadContext(ctx, s, tableName, columnName, term, pf)
+}
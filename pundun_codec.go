@@ -0,0 +1,474 @@
+package pundun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Rows can be encoded from and decoded into Go structs. Exported fields
+// are mapped to columns by their name or by a `pundun:"name"` tag. Fields
+// tagged `pundun:"name,key"` form the key of the row, `pundun:"-"` skips a
+// field and `pundun:"name,omitempty"` leaves out zero values. The fields
+// of embedded structs are mapped as if they were fields of the outer
+// struct, unless the embedded struct is given a name by a tag.
+//
+// Nested structs and maps with string keys map to apollo map values,
+// slices and arrays to list values and []byte to binary values. Nil
+// pointers are written as null and null values decode to nil pointers.
+// Structs without exported fields, such as time.Time, are not supported.
+
+// MarshalRow encodes the struct v into the key and columns of a row.
+func MarshalRow(v interface{}) (key, columns map[string]interface{}, err error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil, errors.New("pundun: MarshalRow of nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("pundun: MarshalRow of non-struct %v", rv.Type())
+	}
+	if isOpaque(rv.Type()) {
+		return nil, nil, fmt.Errorf("pundun: MarshalRow of unsupported type %v", rv.Type())
+	}
+	key = make(map[string]interface{})
+	columns = make(map[string]interface{})
+	for _, f := range structFields(rv.Type()) {
+		fv := fieldOf(rv, f.index, false)
+		if !fv.IsValid() || f.omitEmpty && isZero(fv) {
+			continue
+		}
+		value, err := encodeValue(fv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pundun: field %v: %v", f.name, err)
+		}
+		if f.key {
+			key[f.name] = value
+		} else {
+			columns[f.name] = value
+		}
+	}
+	return key, columns, nil
+}
+
+// UnmarshalRow decodes the key and columns of kvp into the struct
+// pointed to by v. Fields without a matching key field or column are
+// left untouched.
+func UnmarshalRow(kvp KVP, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("pundun: UnmarshalRow needs a non-nil pointer")
+	}
+	return decodeRow(kvp, rv.Elem())
+}
+
+// UnmarshalRows decodes list into the slice pointed to by v, e.g. the
+// List of a KVL into a *[]T or *[]*T.
+func UnmarshalRows(list []KVP, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("pundun: UnmarshalRows needs a pointer to a slice")
+	}
+	slice := rv.Elem()
+	out := reflect.MakeSlice(slice.Type(), len(list), len(list))
+	for i, kvp := range list {
+		if err := decodeRow(kvp, out.Index(i)); err != nil {
+			return err
+		}
+	}
+	slice.Set(out)
+	return nil
+}
+
+// Write the struct row to a pundun table. Key and columns are taken
+// from row as described for MarshalRow.
+func WriteStruct(s Session, tableName string, row interface{}) (interface{}, error) {
+	return WriteStructContext(context.Background(), s, tableName, row)
+}
+
+// WriteStructContext is like WriteStruct but returns ctx.Err() as soon as ctx is done.
+func WriteStructContext(ctx context.Context, s Session, tableName string, row interface{}) (interface{}, error) {
+	key, columns, err := MarshalRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return WriteContext(ctx, s, tableName, key, columns)
+}
+
+// Read a key from pundun table into the struct pointed to by row.
+func ReadInto(s Session, tableName string, key map[string]interface{}, row interface{}) error {
+	return ReadIntoContext(context.Background(), s, tableName, key, row)
+}
+
+// ReadIntoContext is like ReadInto but returns ctx.Err() as soon as ctx is done.
+func ReadIntoContext(ctx context.Context, s Session, tableName string, key map[string]interface{}, row interface{}) error {
+	columns, err := ReadContext(ctx, s, tableName, key)
+	if err != nil {
+		return err
+	}
+	// Decode the key as it would be read back, e.g. an int as int64.
+	return UnmarshalRow(KVP{formatFields(fixFields(key)), columns}, row)
+}
+
+type codecField struct {
+	name      string
+	index     []int
+	key       bool
+	omitEmpty bool
+}
+
+// structFields returns the mapped fields of t. Fields of embedded structs
+// follow the fields of t and are left out when a field of t has the same
+// name.
+func structFields(t reflect.Type) []codecField {
+	fields := make([]codecField, 0, t.NumField())
+	var embedded []codecField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("pundun")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if sf.Anonymous && parts[0] == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr {
+					// A nil pointer to an unexported type cannot be set.
+					continue
+				}
+				for _, f := range structFields(ft) {
+					f.index = append([]int{i}, f.index...)
+					embedded = append(embedded, f)
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := codecField{name: parts[0], index: sf.Index}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "key":
+				f.key = true
+			case "omitempty":
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	for _, f := range embedded {
+		if !hasField(fields, f.name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func hasField(fields []codecField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldOf returns the field of the struct v at index. Nil embedded
+// struct pointers on the way are allocated if alloc is set; otherwise
+// the zero Value is returned for them.
+func fieldOf(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// isOpaque reports whether t is a struct whose state is kept in
+// unexported fields only, e.g. time.Time, and so cannot be mapped.
+func isOpaque(t reflect.Type) bool {
+	if t.NumField() == 0 {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.PkgPath == "" || sf.Anonymous {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// encodeValue converts v to one of the types understood by fixValue.
+func encodeValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("value %v overflows int64", u)
+		}
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice:
+		if v.Type() == bytesType || v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.IsNil() {
+				return nil, nil
+			}
+			return v.Bytes(), nil
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		fallthrough
+	case reflect.Array:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			e, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = e
+		}
+		return list, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %v", v.Type().Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			e, err := encodeValue(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			m[k.String()] = e
+		}
+		return m, nil
+	case reflect.Struct:
+		if isOpaque(v.Type()) {
+			break
+		}
+		m := make(map[string]interface{})
+		for _, f := range structFields(v.Type()) {
+			fv := fieldOf(v, f.index, false)
+			if !fv.IsValid() || f.omitEmpty && isZero(fv) {
+				continue
+			}
+			e, err := encodeValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = e
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", v.Type())
+}
+
+func decodeRow(kvp KVP, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("pundun: cannot decode row into %v", v.Type())
+	}
+	for _, f := range structFields(v.Type()) {
+		src := kvp.Columns
+		if f.key {
+			src = kvp.Key
+		}
+		value, ok := src[f.name]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(value, fieldOf(v, f.index, true)); err != nil {
+			return fmt.Errorf("pundun: field %v: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// isNull reports whether value is the decoded form of an apollo null.
+func isNull(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	b, ok := value.([]byte)
+	return ok && len(b) == 0
+}
+
+// decodeValue stores value, as returned by formatValue, into v.
+func decodeValue(value interface{}, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if isNull(value) && v.Type().Elem() != bytesType {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(value, v.Elem())
+	}
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		if value == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	mismatch := fmt.Errorf("cannot decode %T into %v", value, v.Type())
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := value.(int64)
+		if !ok {
+			return mismatch
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("value %v overflows %v", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := value.(int64)
+		if !ok {
+			return mismatch
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fmt.Errorf("value %v overflows %v", i, v.Type())
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch f := value.(type) {
+		case float64:
+			v.SetFloat(f)
+		case int64:
+			v.SetFloat(float64(f))
+		default:
+			return mismatch
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := value.([]byte)
+			if !ok {
+				return mismatch
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		if isNull(value) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		s := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, e := range list {
+			if err := decodeValue(e, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok || len(list) != v.Len() {
+			return mismatch
+		}
+		for i, e := range list {
+			if err := decodeValue(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if isNull(value) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		m, ok := value.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, e := range m {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(e, ev); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), ev)
+		}
+		v.Set(out)
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok || isOpaque(v.Type()) {
+			return mismatch
+		}
+		for _, f := range structFields(v.Type()) {
+			e, ok := m[f.name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(e, fieldOf(v, f.index, true)); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package pundun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// ErrVerifyFailed is returned by CopyTable when the row counts or
+// checksums of the source and destination tables differ after a copy.
+var ErrVerifyFailed = errors.New("pundun: copy verification failed")
+
+// CopyOptions controls CopyTable.
+type CopyOptions struct {
+	// Options override the source table's options when creating the
+	// destination table, given by name as accepted by CreateTable.
+	Options map[string]interface{}
+	// Existing copies into an existing destination table instead of
+	// creating it.
+	Existing bool
+	// Concurrency bounds the number of batches being written at the same
+	// time. Defaults to 4.
+	Concurrency int
+	// Verify compares the row counts and checksums of both tables once
+	// the rows are copied.
+	Verify bool
+}
+
+// CopyResult reports the outcome of CopyTable. The counts and checksums
+// are only set when verification was requested.
+type CopyResult struct {
+	Rows        int
+	SrcRows     int
+	DstRows     int
+	SrcChecksum uint64
+	DstChecksum uint64
+}
+
+// CopyTable copies a table from the src session to the dst session,
+// which may be connected to different clusters. The destination is
+// created with the source table's key, options and indexes, unless
+// opts.Existing is set. Rows are copied with their exact values.
+func CopyTable(src Session, dst Session, srcTable, dstTable string, opts CopyOptions) (CopyResult, error) {
+	return CopyTableContext(context.Background(), src, dst, srcTable, dstTable, opts)
+}
+
+// CopyTableContext is like CopyTable but returns ctx.Err() as soon as ctx is done.
+func CopyTableContext(ctx context.Context, src Session, dst Session, srcTable, dstTable string,
+	opts CopyOptions) (CopyResult, error) {
+	var res CopyResult
+	if !opts.Existing {
+		info, err := DescribeTableContext(ctx, src, srcTable, nil)
+		if err != nil {
+			return res, err
+		}
+		schema := TableSchema{Name: dstTable, Key: info.Key, Options: info.Options()}
+		for k, v := range opts.Options {
+			if err := parseOption(&schema.Options, k, v); err != nil {
+				return res, err
+			}
+		}
+		for _, index := range info.Indexes {
+			schema.Indexes = append(schema.Indexes, index.Config())
+		}
+		if err := restoreSchema(ctx, dst, dstTable, schema); err != nil {
+			return res, err
+		}
+	}
+	n, err := copyRows(ctx, src, dst, srcTable, dstTable, opts.Concurrency)
+	res.Rows = n
+	if err != nil || !opts.Verify {
+		return res, err
+	}
+	if res.SrcRows, res.SrcChecksum, err = TableChecksum(ctx, src, srcTable); err != nil {
+		return res, err
+	}
+	if res.DstRows, res.DstChecksum, err = TableChecksum(ctx, dst, dstTable); err != nil {
+		return res, err
+	}
+	if res.SrcRows != res.DstRows || res.SrcChecksum != res.DstChecksum {
+		return res, fmt.Errorf("%w: %d rows with checksum %x, copied %d rows with checksum %x", ErrVerifyFailed,
+			res.SrcRows, res.SrcChecksum, res.DstRows, res.DstChecksum)
+	}
+	return res, nil
+}
+
+// copyRows reads the rows of srcTable in pages that are written to
+// dstTable by concurrent batches.
+func copyRows(ctx context.Context, src, dst Session, srcTable, dstTable string, concurrency int) (int, error) {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make(chan []*apollo.KeyColumnsPair, concurrency)
+	errs := make(chan error, concurrency)
+	var written int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				b := NewBatch()
+				for _, kcp := range page {
+					b.writeFields(dstTable, kcp.Key, kcp.Columns)
+				}
+				for _, r := range b.RunContext(ctx, dst) {
+					if r.Err != nil {
+						errs <- r.Err
+						cancel()
+						return
+					}
+				}
+				atomic.AddInt64(&written, int64(len(page)))
+			}
+		}()
+	}
+
+	var page []*apollo.KeyColumnsPair
+	flush := func() error {
+		select {
+		case pages <- page:
+			page = nil
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := readRawRange(ctx, src, srcTable, nil, nil, func(kcp *apollo.KeyColumnsPair) error {
+		page = append(page, kcp)
+		if len(page) < loadBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(page) > 0 {
+		err = flush()
+	}
+	close(pages)
+	wg.Wait()
+	select {
+	case werr := <-errs:
+		err = werr
+	default:
+	}
+	return int(written), err
+}
+
+// TableChecksum reads every row of a table and returns the number of
+// rows and a checksum of their keys and values. The checksum does not
+// depend on the order of the rows, so tables with different comparators
+// can be compared.
+func TableChecksum(ctx context.Context, s Session, tableName string) (int, uint64, error) {
+	n := 0
+	var sum uint64
+	h := fnv.New64a()
+	err := readRawRange(ctx, s, tableName, nil, nil, func(kcp *apollo.KeyColumnsPair) error {
+		n++
+		sum += rowDigest(h, kcp)
+		return nil
+	})
+	return n, sum, err
+}
+
+// rowDigest hashes the key and columns of kcp independently of the
+// order of their fields.
+func rowDigest(h hash.Hash64, kcp *apollo.KeyColumnsPair) uint64 {
+	h.Reset()
+	digestFields(h, kcp.GetKey())
+	h.Write([]byte{0xff})
+	digestFields(h, kcp.GetColumns())
+	return h.Sum64()
+}
+
+func digestFields(h hash.Hash64, fields []*apollo.Field) {
+	sorted := append([]*apollo.Field(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	for _, f := range sorted {
+		digestBytes(h, []byte(f.Name))
+		digestValue(h, f.Value)
+	}
+}
+
+// digestValue writes a type tag followed by the value, so that values
+// of different types never hash alike.
+func digestValue(h hash.Hash64, v *apollo.Value) {
+	buf := make([]byte, 8)
+	switch t := v.GetType().(type) {
+	case *apollo.Value_String_:
+		h.Write([]byte{1})
+		digestBytes(h, []byte(t.String_))
+	case *apollo.Value_Binary:
+		h.Write([]byte{2})
+		digestBytes(h, t.Binary)
+	case *apollo.Value_Int:
+		h.Write([]byte{3})
+		binary.BigEndian.PutUint64(buf, uint64(t.Int))
+		h.Write(buf)
+	case *apollo.Value_Double:
+		h.Write([]byte{4})
+		binary.BigEndian.PutUint64(buf, math.Float64bits(t.Double))
+		h.Write(buf)
+	case *apollo.Value_Boolean:
+		if t.Boolean {
+			h.Write([]byte{5, 1})
+		} else {
+			h.Write([]byte{5, 0})
+		}
+	case *apollo.Value_Null:
+		h.Write([]byte{6})
+	case *apollo.Value_List:
+		h.Write([]byte{7})
+		values := t.List.GetValues()
+		binary.BigEndian.PutUint64(buf, uint64(len(values)))
+		h.Write(buf)
+		for _, e := range values {
+			digestValue(h, e)
+		}
+	case *apollo.Value_Map:
+		h.Write([]byte{8})
+		values := t.Map.GetValues()
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		binary.BigEndian.PutUint64(buf, uint64(len(keys)))
+		h.Write(buf)
+		for _, k := range keys {
+			digestBytes(h, []byte(k))
+			digestValue(h, values[k])
+		}
+	default:
+		h.Write([]byte{0})
+	}
+}
+
+// digestBytes writes b prefixed with its length.
+func digestBytes(h hash.Hash64, b []byte) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(len(b)))
+	h.Write(buf)
+	h.Write(b)
+}
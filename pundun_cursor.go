@@ -0,0 +1,153 @@
+package pundun
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// Cursor iterates over a pundun table from a position obtained by
+// First, Last or Seek. The key columns pair at the starting position is
+// returned by the first call to Next or Prev:
+//
+//	c := FirstCursor(s, "ct")
+//	defer c.Close()
+//	for c.Next() {
+//		kvp := c.KVP()
+//		...
+//	}
+//	if err := c.Err(); err != nil {
+//		...
+//	}
+//
+// A Cursor is not safe for concurrent use.
+type Cursor struct {
+	ctx     context.Context
+	s       Session
+	it      []byte
+	kvp     KVP
+	pending bool
+	err     error
+	done    bool
+}
+
+// FirstCursor returns a cursor positioned at the first key of a table.
+func FirstCursor(s Session, tableName string) *Cursor {
+	return FirstCursorContext(context.Background(), s, tableName)
+}
+
+// FirstCursorContext is like FirstCursor. ctx is used for every call the
+// cursor makes.
+func FirstCursorContext(ctx context.Context, s Session, tableName string) *Cursor {
+	it, err := FirstContext(ctx, s, tableName)
+	return newCursor(ctx, s, it, err)
+}
+
+// LastCursor returns a cursor positioned at the last key of a table.
+func LastCursor(s Session, tableName string) *Cursor {
+	return LastCursorContext(context.Background(), s, tableName)
+}
+
+// LastCursorContext is like LastCursor. ctx is used for every call the
+// cursor makes.
+func LastCursorContext(ctx context.Context, s Session, tableName string) *Cursor {
+	it, err := LastContext(ctx, s, tableName)
+	return newCursor(ctx, s, it, err)
+}
+
+// SeekCursor returns a cursor positioned at key, or the key following
+// it, on a table.
+func SeekCursor(s Session, tableName string, key map[string]interface{}) *Cursor {
+	return SeekCursorContext(context.Background(), s, tableName, key)
+}
+
+// SeekCursorContext is like SeekCursor. ctx is used for every call the
+// cursor makes.
+func SeekCursorContext(ctx context.Context, s Session, tableName string, key map[string]interface{}) *Cursor {
+	it, err := SeekContext(ctx, s, tableName, key)
+	return newCursor(ctx, s, it, err)
+}
+
+func newCursor(ctx context.Context, s Session, it Iterator, err error) *Cursor {
+	c := &Cursor{ctx: ctx, s: s}
+	if err != nil {
+		c.fail(err)
+		return c
+	}
+	c.it = it.It
+	c.kvp = it.Kvp
+	c.pending = true
+	return c
+}
+
+// Next advances the cursor to the following key. It returns false when
+// the table is exhausted or an error occurred, see Err.
+func (c *Cursor) Next() bool {
+	return c.step(&apollo.ApolloPdu{
+		Procedure: &apollo.ApolloPdu_Next{
+			Next: &apollo.Next{It: c.it},
+		},
+	})
+}
+
+// Prev moves the cursor to the preceding key. It returns false when
+// the beginning of the table is passed or an error occurred, see Err.
+func (c *Cursor) Prev() bool {
+	return c.step(&apollo.ApolloPdu{
+		Procedure: &apollo.ApolloPdu_Prev{
+			Prev: &apollo.Prev{It: c.it},
+		},
+	})
+}
+
+func (c *Cursor) step(pdu *apollo.ApolloPdu) bool {
+	if c.done {
+		return false
+	}
+	if c.pending {
+		c.pending = false
+		return true
+	}
+	it, err := iteratorStep(c.ctx, c.s, pdu)
+	if err != nil {
+		c.fail(err)
+		return false
+	}
+	if it.It != nil {
+		c.it = it.It
+	}
+	c.kvp = it.Kvp
+	return true
+}
+
+// fail ends the cursor. Reaching the end of the table is not an error.
+func (c *Cursor) fail(err error) {
+	c.done = true
+	c.it = nil
+	c.kvp = KVP{}
+	if !errors.Is(err, ErrEndOfTable) {
+		c.err = err
+	}
+}
+
+// KVP returns the key columns pair at the current position.
+func (c *Cursor) KVP() KVP {
+	return c.kvp
+}
+
+// Err returns the error that ended the cursor, if any. Reaching either
+// end of the table is not reported as an error.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close ends the cursor and drops its iterator handle. The apollo
+// protocol has no procedure to release an iterator explicitly; the node
+// releases it when it expires. Close is safe to call more than once.
+func (c *Cursor) Close() error {
+	c.done = true
+	c.pending = false
+	c.it = nil
+	return nil
+}
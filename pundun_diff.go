@@ -0,0 +1,308 @@
+package pundun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/falkevik/pundun/internal/order"
+	"github.com/pundunlabs/apollo"
+)
+
+// Kinds of row differences found by DiffTables.
+const (
+	MissingRow = 0 // the key is only in the first table
+	ExtraRow   = 1 // the key is only in the second table
+	ChangedRow = 2 // the key is in both tables with different columns
+)
+
+// DiffOptions controls DiffTables.
+type DiffOptions struct {
+	// Start and End bound the compared key range, as for Export.
+	Start map[string]interface{}
+	End   map[string]interface{}
+	// Report is called for each difference in key order. The diff stops
+	// with the first error returned by Report.
+	Report func(RowDiff) error
+	// Repair, when set, receives a repair script that makes the second
+	// table equal to the first: one JSON object per line with "op" set
+	// to "write" or "delete", "table", "key" and, for writes, "columns".
+	// Each key and column value is an object tagged with its type, e.g.
+	// {"int":1}, {"double":1}, {"string":"a"}, {"binary":"AQI="},
+	// {"boolean":true}, {"null":true}, {"list":[...]} or {"map":{...}},
+	// so that it can be written back with the type it was read with.
+	Repair io.Writer
+}
+
+// RowDiff is a difference between two tables. A and B are the columns
+// of the row in either table, nil when the key is missing there.
+type RowDiff struct {
+	Kind    int
+	Key     map[string]interface{}
+	A       map[string]interface{}
+	B       map[string]interface{}
+	Columns []ColumnDiff // for ChangedRow
+}
+
+// ColumnDiff is a column whose value differs between two rows. A and B
+// hold the column's value in either row, nil when missing.
+type ColumnDiff struct {
+	Column string
+	A      interface{}
+	B      interface{}
+}
+
+// DiffSummary counts the rows compared by DiffTables and their
+// differences.
+type DiffSummary struct {
+	Rows    int // distinct keys read from both tables
+	Missing int
+	Extra   int
+	Changed int
+}
+
+// repairOp is a line of a repair script.
+type repairOp struct {
+	Op      string                 `json:"op"`
+	Table   string                 `json:"table"`
+	Key     map[string]interface{} `json:"key"`
+	Columns map[string]interface{} `json:"columns,omitempty"`
+}
+
+// DiffTables compares table aTable on session a with table bTable on
+// session b. Both tables are read in key order at the same time and
+// must have the same key and comparator.
+//
+// Keys are ordered field by field as given by the table's key, in the
+// order of a node: values of the same type compare naturally; values of
+// different types are ordered null, numbers, booleans, strings,
+// binaries, lists and maps.
+func DiffTables(ctx context.Context, a Session, aTable string, b Session, bTable string,
+	opts DiffOptions) (DiffSummary, error) {
+	var sum DiffSummary
+	attrs := []string{"key", "comparator"}
+	ainfo, err := DescribeTableContext(ctx, a, aTable, attrs)
+	if err != nil {
+		return sum, err
+	}
+	binfo, err := DescribeTableContext(ctx, b, bTable, attrs)
+	if err != nil {
+		return sum, err
+	}
+	if !reflect.DeepEqual(ainfo.Key, binfo.Key) || ainfo.Comparator != binfo.Comparator {
+		return sum, fmt.Errorf("cannot diff %s with key %v, %s and %s with key %v, %s", aTable, ainfo.Key,
+			ainfo.Comparator, bTable, binfo.Key, binfo.Comparator)
+	}
+	cmp := func(x, y []*apollo.Field) int {
+		c := compareKeys(ainfo.Key, x, y)
+		if ainfo.Comparator == "descending" {
+			return -c
+		}
+		return c
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	as := streamRows(ctx, a, aTable, opts.Start, opts.End)
+	bs := streamRows(ctx, b, bTable, opts.Start, opts.End)
+
+	var enc *json.Encoder
+	if opts.Repair != nil {
+		enc = json.NewEncoder(opts.Repair)
+	}
+	report := func(d RowDiff, x, y streamRow) error {
+		switch d.Kind {
+		case MissingRow:
+			sum.Missing++
+		case ExtraRow:
+			sum.Extra++
+		case ChangedRow:
+			sum.Changed++
+		}
+		if enc != nil {
+			op := repairOp{Op: "write", Table: bTable, Key: typedFields(x.raw.GetKey()),
+				Columns: typedFields(x.raw.GetColumns())}
+			if d.Kind == ExtraRow {
+				op = repairOp{Op: "delete", Table: bTable, Key: typedFields(y.raw.GetKey())}
+			}
+			if err := enc.Encode(op); err != nil {
+				return err
+			}
+		}
+		if opts.Report != nil {
+			return opts.Report(d)
+		}
+		return nil
+	}
+
+	x, xok, err := as.next()
+	if err != nil {
+		return sum, err
+	}
+	y, yok, err := bs.next()
+	if err != nil {
+		return sum, err
+	}
+	for xok || yok {
+		sum.Rows++
+		var d *RowDiff
+		dx, dy := x, y
+		c := 0
+		if !yok {
+			c = -1
+		} else if !xok {
+			c = 1
+		} else {
+			c = cmp(x.raw.GetKey(), y.raw.GetKey())
+		}
+		switch {
+		case c < 0:
+			d = &RowDiff{Kind: MissingRow, Key: x.Key, A: x.Columns}
+			x, xok, err = as.next()
+		case c > 0:
+			d = &RowDiff{Kind: ExtraRow, Key: y.Key, B: y.Columns}
+			y, yok, err = bs.next()
+		default:
+			if columns := diffColumns(x.Columns, y.Columns); len(columns) > 0 {
+				d = &RowDiff{Kind: ChangedRow, Key: x.Key, A: x.Columns, B: y.Columns, Columns: columns}
+			}
+			if x, xok, err = as.next(); err == nil {
+				y, yok, err = bs.next()
+			}
+		}
+		// A table whose read failed is not exhausted, so nothing past the
+		// failure is reported.
+		if err != nil {
+			return sum, err
+		}
+		if d != nil {
+			if err := report(*d, dx, dy); err != nil {
+				return sum, err
+			}
+		}
+	}
+	return sum, nil
+}
+
+// streamRow is a row read by streamRows, formatted and as received.
+type streamRow struct {
+	KVP
+	raw *apollo.KeyColumnsPair
+}
+
+// rowStream is a table being read by streamRows.
+type rowStream struct {
+	rows <-chan streamRow
+	errc <-chan error
+}
+
+// next returns the next row, or ok false once the table is read. A
+// failed read is returned as soon as the rows before it are consumed.
+func (rs rowStream) next() (streamRow, bool, error) {
+	row, ok := <-rs.rows
+	if ok {
+		return row, true, nil
+	}
+	return row, false, <-rs.errc
+}
+
+// streamRows reads a table from start to end in the background.
+func streamRows(ctx context.Context, s Session, tableName string, start, end map[string]interface{}) rowStream {
+	rows := make(chan streamRow, exportBatch)
+	errc := make(chan error, 1)
+	go func() {
+		err := readRawRange(ctx, s, tableName, start, end, func(kcp *apollo.KeyColumnsPair) error {
+			select {
+			case rows <- streamRow{formatKcp(kcp), kcp}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		errc <- err
+		close(rows)
+	}()
+	return rowStream{rows, errc}
+}
+
+// typedFields returns fields by name with their values tagged by type,
+// as written to repair scripts.
+func typedFields(fields []*apollo.Field) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		m[f.GetName()] = typedValue(f.GetValue())
+	}
+	return m
+}
+
+func typedValue(v *apollo.Value) interface{} {
+	switch v.GetType().(type) {
+	case *apollo.Value_Boolean:
+		return map[string]interface{}{"boolean": v.GetBoolean()}
+	case *apollo.Value_Int:
+		return map[string]interface{}{"int": v.GetInt()}
+	case *apollo.Value_Binary:
+		return map[string]interface{}{"binary": v.GetBinary()}
+	case *apollo.Value_Double:
+		return map[string]interface{}{"double": v.GetDouble()}
+	case *apollo.Value_String_:
+		return map[string]interface{}{"string": v.GetString_()}
+	case *apollo.Value_List:
+		list := make([]interface{}, 0, len(v.GetList().GetValues()))
+		for _, e := range v.GetList().GetValues() {
+			list = append(list, typedValue(e))
+		}
+		return map[string]interface{}{"list": list}
+	case *apollo.Value_Map:
+		m := make(map[string]interface{}, len(v.GetMap().GetValues()))
+		for k, e := range v.GetMap().GetValues() {
+			m[k] = typedValue(e)
+		}
+		return map[string]interface{}{"map": m}
+	}
+	return map[string]interface{}{"null": true}
+}
+
+// diffColumns lists the columns that differ between a and b, sorted by
+// name.
+func diffColumns(a, b map[string]interface{}) []ColumnDiff {
+	var diffs []ColumnDiff
+	for k, x := range a {
+		if y, ok := b[k]; !ok || !reflect.DeepEqual(x, y) {
+			diffs = append(diffs, ColumnDiff{k, x, y})
+		}
+	}
+	for k, y := range b {
+		if _, ok := a[k]; !ok {
+			diffs = append(diffs, ColumnDiff{k, nil, y})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Column < diffs[j].Column
+	})
+	return diffs
+}
+
+// compareKeys orders keys by the fields of keyDef in turn, as a node
+// orders them.
+func compareKeys(keyDef []string, a, b []*apollo.Field) int {
+	for _, name := range keyDef {
+		if c := order.Compare(fieldValue(a, name), fieldValue(b, name)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// fieldValue returns the value of the named field, nil when missing.
+func fieldValue(fields []*apollo.Field, name string) *apollo.Value {
+	for _, f := range fields {
+		if f.GetName() == name {
+			return f.GetValue()
+		}
+	}
+	return nil
+}
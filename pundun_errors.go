@@ -0,0 +1,119 @@
+package pundun
+
+import (
+	"errors"
+	"strings"
+)
+
+// Sentinel errors. Errors returned by procedure calls can be matched
+// against these with errors.Is.
+var (
+	ErrNotFound       = errors.New("pundun: not found")
+	ErrTableNotFound  = errors.New("pundun: table not found")
+	ErrTableExists    = errors.New("pundun: table exists")
+	ErrTableClosed    = errors.New("pundun: table closed")
+	ErrInvalidRequest = errors.New("pundun: invalid request")
+	ErrEndOfTable     = errors.New("pundun: end of table")
+	ErrTimeout        = errors.New("pundun: timeout")
+	ErrSessionClosed  = errors.New("pundun: session closed")
+
+	// ErrTooManyRequests is returned when no correlation id is free
+	// because too many requests are in flight on the session.
+	ErrTooManyRequests = errors.New("pundun: too many in-flight requests")
+
+	// ErrConnectionLost is returned by a resilient session for requests
+	// that were in flight when its connection dropped. See IsRetriable.
+	ErrConnectionLost = errors.New("pundun: connection lost")
+)
+
+// IsRetriable reports whether err means the request may not have reached
+// the node and can safely be issued again once the session reconnects.
+func IsRetriable(err error) bool {
+	return errors.Is(err, ErrConnectionLost)
+}
+
+// TransportError is returned when the node reports a transport error.
+type TransportError struct {
+	Reason string
+}
+
+func (e *TransportError) Error() string { return "transport: " + e.Reason }
+func (e *TransportError) Unwrap() error { return classify(e.Reason) }
+
+// ProtocolError is returned when the node could not process the request pdu.
+type ProtocolError struct {
+	Reason string
+}
+
+func (e *ProtocolError) Error() string { return "protocol: " + e.Reason }
+func (e *ProtocolError) Unwrap() error { return classify(e.Reason) }
+
+// SystemError is returned when the procedure failed on the node.
+type SystemError struct {
+	Reason string
+}
+
+func (e *SystemError) Error() string { return "system: " + e.Reason }
+func (e *SystemError) Unwrap() error { return classify(e.Reason) }
+
+// MiscError is returned for any other error reported by the node.
+type MiscError struct {
+	Reason string
+}
+
+func (e *MiscError) Error() string { return "misc: " + e.Reason }
+func (e *MiscError) Unwrap() error { return classify(e.Reason) }
+
+// Known reasons reported by pundun nodes, normalized by normalizeReason.
+var reasons = map[string]error{
+	"not_found":        ErrNotFound,
+	"no_table":         ErrTableNotFound,
+	"table_not_found":  ErrTableNotFound,
+	"no_such_table":    ErrTableNotFound,
+	"table_exists":     ErrTableExists,
+	"already_exists":   ErrTableExists,
+	"table_closed":     ErrTableClosed,
+	"badarg":           ErrInvalidRequest,
+	"invalid_request":  ErrInvalidRequest,
+	"invalid_argument": ErrInvalidRequest,
+	"end_of_table":     ErrEndOfTable,
+	"timeout":          ErrTimeout,
+}
+
+// endOfTableError is an "invalid" reason reported for an iterator
+// procedure, which pundun uses when the iterator runs past either end of
+// the table. For other procedures the reason keeps its usual meaning.
+type endOfTableError struct {
+	error
+}
+
+func (e endOfTableError) Unwrap() []error { return []error{e.error, ErrEndOfTable} }
+
+// iteratorError classifies err of an iterator procedure, i.e. First,
+// Last, Seek, Next or Prev.
+func iteratorError(err error) error {
+	var misc *MiscError
+	var sys *SystemError
+	switch {
+	case errors.As(err, &misc) && normalizeReason(misc.Reason) == "invalid",
+		errors.As(err, &sys) && normalizeReason(sys.Reason) == "invalid":
+		return endOfTableError{err}
+	}
+	return err
+}
+
+// classify maps a reason string from the node to a sentinel error.
+// It returns nil when the reason is not known.
+func classify(reason string) error {
+	return reasons[normalizeReason(reason)]
+}
+
+// normalizeReason strips erlang term decoration so that e.g.
+// "{error,not_found}", "\"no_table\"" and "Not Found" compare equal.
+func normalizeReason(reason string) string {
+	r := strings.ToLower(strings.TrimSpace(reason))
+	r = strings.TrimPrefix(r, "{error,")
+	r = strings.Trim(r, "{}\"' ")
+	r = strings.Replace(r, " ", "_", -1)
+	return r
+}
@@ -0,0 +1,285 @@
+package pundun
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pundunlabs/apollo"
+)
+
+// dumpMagic starts every dump and ends with the format version.
+const (
+	dumpMagic = "pundump\x01"
+)
+
+// Number of keys requested per read by Export.
+const (
+	exportBatch = 1000
+)
+
+// ExportOptions controls Export.
+type ExportOptions struct {
+	// Start and End bound the exported key range. A nil Start begins at
+	// the first key of the table and a nil End continues to its last key.
+	Start map[string]interface{}
+	End   map[string]interface{}
+	// Fields are the CSV columns. Defaults to the key fields followed by
+	// the columns of the first row, each sorted by name.
+	Fields []string
+}
+
+// Export writes the rows of a pundun table to w in the given format and
+// returns the number of rows written. Rows are read in key order
+// following the continuations returned by ReadRange.
+//
+// NDJSON and CSV rows hold the key fields and columns side by side so
+// that Load can read them back. Binary values are base64 encoded and CSV
+// lists and maps are JSON encoded. Only Dump keeps the exact apollo value
+// types, including null, binary and nested lists and maps: each row is
+// an apollo KeyColumnsPair prefixed with its 4-byte big-endian length,
+// following an 8-byte header.
+func Export(ctx context.Context, s Session, tableName string, w io.Writer, format int, opts ExportOptions) (int, error) {
+	bw := bufio.NewWriter(w)
+	var write func(*apollo.KeyColumnsPair) error
+	flush := bw.Flush
+	switch format {
+	case NDJSON:
+		enc := json.NewEncoder(bw)
+		write = func(kcp *apollo.KeyColumnsPair) error {
+			return enc.Encode(flattenKvp(formatKcp(kcp)))
+		}
+	case CSV:
+		cw := csv.NewWriter(bw)
+		header := opts.Fields
+		if header != nil {
+			cw.Write(header)
+		}
+		write = func(kcp *apollo.KeyColumnsPair) error {
+			kvp := formatKcp(kcp)
+			if header == nil {
+				header = csvHeader(kvp)
+				cw.Write(header)
+			}
+			row := flattenKvp(kvp)
+			record := make([]string, len(header))
+			for i, field := range header {
+				record[i] = csvValue(row[field])
+			}
+			cw.Write(record)
+			return cw.Error()
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	case Dump:
+		bw.WriteString(dumpMagic)
+		write = func(kcp *apollo.KeyColumnsPair) error {
+			return writeDumpRecord(bw, kcp)
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %d", format)
+	}
+	n := 0
+	err := readRawRange(ctx, s, tableName, opts.Start, opts.End, func(kcp *apollo.KeyColumnsPair) error {
+		if err := write(kcp); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// ReadDump calls fn for each row of a dump written by Export, until fn
+// returns an error. As with every read, null values are returned as
+// empty byte slices.
+func ReadDump(r io.Reader, fn func(KVP) error) error {
+	next, err := dumpReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		kcp, err := next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(formatKcp(kcp)); err != nil {
+			return err
+		}
+	}
+}
+
+// dumpReader checks the header of a dump and returns a function reading
+// its rows one by one until io.EOF.
+func dumpReader(r io.Reader) (func() (*apollo.KeyColumnsPair, error), error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(dumpMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != dumpMagic {
+		return nil, errors.New("not a pundun dump")
+	}
+	return func() (*apollo.KeyColumnsPair, error) {
+		lenBuf := make([]byte, 4)
+		if _, err := io.ReadFull(br, lenBuf); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated dump")
+			}
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(lenBuf))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, errors.New("truncated dump")
+		}
+		kcp := &apollo.KeyColumnsPair{}
+		if err := proto.Unmarshal(buf, kcp); err != nil {
+			return nil, err
+		}
+		return kcp, nil
+	}, nil
+}
+
+func writeDumpRecord(w io.Writer, kcp *apollo.KeyColumnsPair) error {
+	buf, err := proto.Marshal(kcp)
+	if err != nil {
+		return err
+	}
+	lenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(buf)))
+	if _, err := w.Write(lenBuf); err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// readRawRange calls fn with each key columns pair from start to end as
+// received, before its values are converted. A nil start begins at the
+// first key and a nil end continues to the last key. Pages are followed
+// as by followPages.
+func readRawRange(ctx context.Context, s Session, tableName string, start, end map[string]interface{},
+	fn func(*apollo.KeyColumnsPair) error) error {
+	var from []*apollo.Field
+	if start != nil {
+		from = fixFields(start)
+	} else {
+		pdu := &apollo.ApolloPdu{
+			Procedure: &apollo.ApolloPdu_First{
+				First: &apollo.First{TableName: tableName},
+			},
+		}
+		r, err := run_raw_transaction(ctx, s, pdu)
+		if err = iteratorError(err); errors.Is(err, ErrEndOfTable) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		from = r.GetKcpIt().GetKeyColumnsPair().GetKey()
+	}
+	fetch := func(from []*apollo.Field, count int) ([]*apollo.KeyColumnsPair, []*apollo.Field, bool, error) {
+		pdu := &apollo.ApolloPdu{}
+		if end != nil {
+			pdu.Procedure = &apollo.ApolloPdu_ReadRange{
+				ReadRange: &apollo.ReadRange{
+					TableName: tableName,
+					StartKey:  from,
+					EndKey:    fixFields(end),
+					Limit:     uint32(count),
+				},
+			}
+		} else {
+			pdu.Procedure = &apollo.ApolloPdu_ReadRangeN{
+				ReadRangeN: &apollo.ReadRangeN{
+					TableName: tableName,
+					StartKey:  from,
+					N:         uint32(count),
+				},
+			}
+		}
+		r, err := run_raw_transaction(ctx, s, pdu)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		kcl := r.GetKeyColumnsList()
+		cont := kcl.GetContinuation().GetKey()
+		return kcl.GetList(), cont, len(cont) > 0, nil
+	}
+	same := func(a, b []*apollo.Field) bool {
+		return reflect.DeepEqual(formatFields(a), formatFields(b))
+	}
+	var fnErr error
+	err := followPages(from, -1, exportBatch, fetch, (*apollo.KeyColumnsPair).GetKey, same,
+		func(kcp *apollo.KeyColumnsPair) bool {
+			fnErr = fn(kcp)
+			return fnErr == nil
+		})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// flattenKvp merges the key fields and columns of kvp into one map.
+func flattenKvp(kvp KVP) map[string]interface{} {
+	row := make(map[string]interface{}, len(kvp.Key)+len(kvp.Columns))
+	for k, v := range kvp.Columns {
+		row[k] = v
+	}
+	for k, v := range kvp.Key {
+		row[k] = v
+	}
+	return row
+}
+
+// csvHeader lists the key fields of kvp followed by its columns.
+func csvHeader(kvp KVP) []string {
+	var key, columns []string
+	for k := range kvp.Key {
+		key = append(key, k)
+	}
+	for k := range kvp.Columns {
+		if _, ok := kvp.Key[k]; !ok {
+			columns = append(columns, k)
+		}
+	}
+	sort.Strings(key)
+	sort.Strings(columns)
+	return append(key, columns...)
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,223 @@
+package pundun
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TableInfoResult is the typed form of the attributes returned by
+// TableInfo. Attributes that are not known, or whose value does not have
+// the expected shape, are kept in Raw.
+type TableInfoResult struct {
+	Name              string      `pundun:"name"`
+	Key               []string    `pundun:"key"`
+	Columns           []string    `pundun:"columns"`
+	Type              string      `pundun:"type"`
+	DataModel         string      `pundun:"data_model"`
+	Comparator        string      `pundun:"comparator"`
+	TimeSeries        bool        `pundun:"time_series"`
+	Distributed       bool        `pundun:"distributed"`
+	NumOfShards       int         `pundun:"num_of_shards"`
+	HashingMethod     string      `pundun:"hashing_method"`
+	ReplicationFactor int         `pundun:"replication_factor"`
+	HashExclude       []string    `pundun:"hash_exclude"`
+	TTL               int64       `pundun:"ttl"`
+	Indexes           []IndexInfo `pundun:"index_on"`
+	Size              int64       `pundun:"size"`
+	Memory            int64       `pundun:"memory"`
+	Shards            []ShardInfo `pundun:"shards"`
+
+	Raw map[string]interface{} `pundun:"-"`
+
+	// reported holds the attributes set by ParseTableInfo.
+	reported map[string]bool
+}
+
+// IndexInfo describes an indexed column of a table.
+type IndexInfo struct {
+	Column  string                 `pundun:"column"`
+	Options map[string]interface{} `pundun:"options"`
+}
+
+// ShardInfo describes a shard of a table and the node holding it.
+type ShardInfo struct {
+	Name string `pundun:"name"`
+	Node string `pundun:"node"`
+}
+
+// TableInfoDiff is a difference between two table infos.
+// A and B hold the attribute's value on either side, nil when missing.
+type TableInfoDiff struct {
+	Attribute string
+	A         interface{}
+	B         interface{}
+}
+
+// Retrieve typed table information. When attrs is empty all attributes
+// are requested.
+func DescribeTable(s Session, tableName string, attrs []string) (TableInfoResult, error) {
+	return DescribeTableContext(context.Background(), s, tableName, attrs)
+}
+
+// DescribeTableContext is like DescribeTable but returns ctx.Err() as soon as ctx is done.
+func DescribeTableContext(ctx context.Context, s Session, tableName string, attrs []string) (TableInfoResult, error) {
+	res, err := TableInfoContext(ctx, s, tableName, attrs)
+	if err != nil {
+		return TableInfoResult{}, err
+	}
+	m, ok := res.(map[string]interface{})
+	if !ok {
+		return TableInfoResult{}, errors.New("invalid response")
+	}
+	return ParseTableInfo(m), nil
+}
+
+// ParseTableInfo converts the attribute map returned by TableInfo.
+func ParseTableInfo(m map[string]interface{}) TableInfoResult {
+	var info TableInfoResult
+	rv := reflect.ValueOf(&info).Elem()
+	known := make(map[string]bool)
+	for _, f := range structFields(rv.Type()) {
+		value, ok := m[f.name]
+		if !ok {
+			continue
+		}
+		value = normalizeInfo(f.name, value)
+		field := reflect.New(rv.FieldByIndex(f.index).Type()).Elem()
+		if err := decodeValue(value, field); err != nil {
+			continue
+		}
+		rv.FieldByIndex(f.index).Set(field)
+		known[f.name] = true
+	}
+	info.reported = known
+	for k, v := range m {
+		if !known[k] {
+			if info.Raw == nil {
+				info.Raw = make(map[string]interface{})
+			}
+			info.Raw[k] = v
+		}
+	}
+	return info
+}
+
+// normalizeInfo turns the short forms of list attributes, where only
+// names are given, into their map form.
+func normalizeInfo(attr string, value interface{}) interface{} {
+	var field string
+	switch attr {
+	case "index_on":
+		field = "column"
+	case "shards":
+		field = "name"
+	default:
+		return value
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	out := make([]interface{}, len(list))
+	for i, e := range list {
+		if name, ok := e.(string); ok {
+			out[i] = map[string]interface{}{field: name}
+		} else {
+			out[i] = e
+		}
+	}
+	return out
+}
+
+// DiffTableInfo lists the attributes that differ between a and b,
+// including attributes only kept in Raw, sorted by attribute name.
+func DiffTableInfo(a, b TableInfoResult) []TableInfoDiff {
+	diffs := make([]TableInfoDiff, 0)
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	for _, f := range structFields(av.Type()) {
+		x := av.FieldByIndex(f.index).Interface()
+		y := bv.FieldByIndex(f.index).Interface()
+		if !reflect.DeepEqual(x, y) {
+			diffs = append(diffs, TableInfoDiff{f.name, x, y})
+		}
+	}
+	for k, x := range a.Raw {
+		y, ok := b.Raw[k]
+		if !ok || !reflect.DeepEqual(x, y) {
+			diffs = append(diffs, TableInfoDiff{k, x, y})
+		}
+	}
+	for k, y := range b.Raw {
+		if _, ok := a.Raw[k]; !ok {
+			diffs = append(diffs, TableInfoDiff{k, nil, y})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Attribute < diffs[j].Attribute
+	})
+	return diffs
+}
+
+// Options returns the creation options of the described table, for
+// creating a table like it. Wrapper and tda settings are not reported by
+// TableInfo and are left unset, as are time_series and distributed when
+// they were not reported as set, so the node defaults apply.
+func (info TableInfoResult) Options() TableOptions {
+	var opts TableOptions
+	if t, ok := tableTypes[info.Type]; ok {
+		opts.Type = &t
+	}
+	if hm, ok := hashingMethods[info.HashingMethod]; ok {
+		opts.HashingMethod = &hm
+	}
+	opts.DataModel = info.DataModel
+	opts.Comparator = info.Comparator
+	if info.TimeSeries || info.reported["time_series"] {
+		opts.TimeSeries = Bool(info.TimeSeries)
+	}
+	if info.Distributed || info.reported["distributed"] {
+		opts.Distributed = Bool(info.Distributed)
+	}
+	opts.NumOfShards = uint32(info.NumOfShards)
+	opts.ReplicationFactor = uint32(info.ReplicationFactor)
+	opts.HashExclude = info.HashExclude
+	opts.TTL = uint32(info.TTL)
+	return opts
+}
+
+var charFilters = map[string]int{"nfc": NFC, "nfd": NFD, "nfkc": NFKC, "nfkd": NFKD}
+var tokenTransforms = map[string]int{"lowercase": LOWERCASE, "uppercase": UPPERCASE, "casefold": CASEFOLD}
+var tokenStats = map[string]int{"nostats": NOSTATS, "unique": UNIQUE, "frequency": FREQUENCY, "position": POSITION}
+
+// Config returns the index configuration of the described index, for
+// adding it to another table. Unknown options are left to their defaults.
+func (index IndexInfo) Config() IndexConfig {
+	config := IndexConfig{Column: index.Column}
+	enum := func(v interface{}, names map[string]int, max int) int {
+		if name, ok := v.(string); ok {
+			v = strings.ToLower(name)
+		}
+		e, _ := enumOption(v, names, max)
+		return e
+	}
+	strs := func(v interface{}) []string {
+		var l []string
+		decodeValue(v, reflect.ValueOf(&l).Elem())
+		return l
+	}
+	opts := index.Options
+	config.Options.CharFilter = enum(opts["char_filter"], charFilters, NFKD)
+	if filter, ok := opts["token_filter"].(map[string]interface{}); ok {
+		config.Options.TokenFilter = TokenFilter{
+			Transform: enum(filter["transform"], tokenTransforms, CASEFOLD),
+			Add:       strs(filter["add"]),
+			Delete:    strs(filter["delete"]),
+			Stats:     enum(filter["stats"], tokenStats, POSITION),
+		}
+	}
+	return config
+}
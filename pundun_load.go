@@ -0,0 +1,414 @@
+package pundun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// Formats read by Load and written by Export.
+const (
+	CSV    = 0 // first line is the header unless LoadMapping.Header is set
+	JSON   = 1 // a single array of objects
+	NDJSON = 2 // one object per line
+	Dump   = 3 // length-prefixed apollo key columns pairs, see Export
+)
+
+// Field types source values are coerced to by Load.
+const (
+	AutoType    = 0 // JSON numbers become int64 or float64, others are kept
+	StringType  = 1
+	IntType     = 2
+	DoubleType  = 3
+	BooleanType = 4
+	BinaryType  = 5 // base64 encoded string
+)
+
+// Number of rows written per batch by Load, and the longest NDJSON line
+// it reads.
+const (
+	loadBatch = 500
+	maxLine   = 64 << 20
+)
+
+// LoadMapping describes how Load maps source rows to pundun keys and
+// columns.
+type LoadMapping struct {
+	Key     []string          // source fields making up the key
+	Columns []string          // source fields written as columns, all non key fields when empty
+	Names   map[string]string // pundun field names of renamed source fields
+	Types   map[string]int    // coercion of source fields, AutoType when missing
+	Header  []string          // CSV field names when the input has no header line
+	Comma   rune              // CSV field delimiter, ',' when zero
+
+	BatchSize int             // rows written per batch
+	Offset    int             // rows skipped before loading, to resume a load
+	Progress  func(LoadStats) // called after each batch
+	ErrorLog  io.Writer       // receives a line for each failed row
+}
+
+// LoadStats reports the progress of Load. Offset is the number of rows
+// consumed up to the last completed batch; a load is resumed by passing
+// it as LoadMapping.Offset.
+type LoadStats struct {
+	Offset  int
+	Written int
+	Failed  int
+}
+
+// rowError is a failure that only affects the row being read.
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string {
+	return e.err.Error()
+}
+
+// Load writes the rows read from r in the given format to a pundun table.
+// Dump rows are written with their exact values and the key and column
+// settings of mapping are ignored for them. Rows that cannot be parsed,
+// coerced or written are counted as failed and logged to
+// mapping.ErrorLog; they do not stop the load. Load stops on the first
+// error reading r or when ctx is done, returning the stats of the rows
+// written so far.
+func Load(ctx context.Context, s Session, tableName string, r io.Reader, format int,
+	mapping LoadMapping) (LoadStats, error) {
+	var stats LoadStats
+	next, err := loadReader(r, format, mapping)
+	if err != nil {
+		return stats, err
+	}
+	size := mapping.BatchSize
+	if size <= 0 {
+		size = loadBatch
+	}
+	fail := func(row int, err error) {
+		stats.Failed++
+		if mapping.ErrorLog != nil {
+			fmt.Fprintf(mapping.ErrorLog, "row %d: %v\n", row, err)
+		}
+	}
+
+	b := NewBatch()
+	var rows []int
+	consumed := 0
+	flush := func() error {
+		if b.Len() > 0 {
+			results := b.RunContext(ctx, s)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for i, res := range results {
+				if res.Err != nil {
+					fail(rows[i], res.Err)
+				} else {
+					stats.Written++
+				}
+			}
+			b = NewBatch()
+			rows = rows[:0]
+		}
+		stats.Offset = consumed
+		if mapping.Progress != nil {
+			mapping.Progress(stats)
+		}
+		return nil
+	}
+
+	for row := 0; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		kcp, err := next()
+		if err == io.EOF {
+			break
+		}
+		var re rowError
+		if errors.As(err, &re) {
+			if row >= mapping.Offset {
+				fail(row, re.err)
+			}
+			consumed = row + 1
+			continue
+		} else if err != nil {
+			return stats, err
+		}
+		consumed = row + 1
+		if row < mapping.Offset {
+			continue
+		}
+		b.writeFields(tableName, kcp.Key, kcp.Columns)
+		rows = append(rows, row)
+		if b.Len() >= size {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, flush()
+}
+
+// loadReader returns a function reading the rows of r one by one until
+// io.EOF.
+func loadReader(r io.Reader, format int, mapping LoadMapping) (func() (*apollo.KeyColumnsPair, error), error) {
+	if format == Dump {
+		return dumpReader(r)
+	}
+	next, err := sourceReader(r, format, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*apollo.KeyColumnsPair, error) {
+		src, err := next()
+		if err != nil {
+			return nil, err
+		}
+		key, columns, err := mapRow(src, mapping)
+		if err != nil {
+			return nil, rowError{err}
+		}
+		return &apollo.KeyColumnsPair{Key: fixFields(key), Columns: fixFields(columns)}, nil
+	}, nil
+}
+
+// sourceReader returns a function reading the source rows of r one by
+// one until io.EOF.
+func sourceReader(r io.Reader, format int, mapping LoadMapping) (func() (map[string]interface{}, error), error) {
+	switch format {
+	case CSV:
+		cr := csv.NewReader(r)
+		if mapping.Comma != 0 {
+			cr.Comma = mapping.Comma
+		}
+		cr.FieldsPerRecord = -1
+		header := mapping.Header
+		if header == nil {
+			h, err := cr.Read()
+			if err != nil {
+				return nil, err
+			}
+			header = append([]string(nil), h...)
+		}
+		return func() (map[string]interface{}, error) {
+			record, err := cr.Read()
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return nil, rowError{err}
+			} else if err != nil {
+				return nil, err
+			}
+			if len(record) != len(header) {
+				return nil, rowError{fmt.Errorf("expected %d fields, got %d", len(header), len(record))}
+			}
+			src := make(map[string]interface{}, len(header))
+			for i, name := range header {
+				src[name] = record[i]
+			}
+			return src, nil
+		}, nil
+	case JSON:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		if tok, err := dec.Token(); err != nil {
+			return nil, err
+		} else if tok != json.Delim('[') {
+			return nil, errors.New("expected a JSON array")
+		}
+		done := false
+		return func() (map[string]interface{}, error) {
+			if done {
+				return nil, io.EOF
+			}
+			if dec.More() {
+				return decodeObject(dec)
+			}
+			// The array must be closed, or the input was cut short.
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			} else if tok != json.Delim(']') {
+				return nil, fmt.Errorf("unexpected %v in JSON array", tok)
+			}
+			done = true
+			return nil, io.EOF
+		}, nil
+	case NDJSON:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, maxLine)
+		return func() (map[string]interface{}, error) {
+			for sc.Scan() {
+				line := bytes.TrimSpace(sc.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				dec := json.NewDecoder(bytes.NewReader(line))
+				dec.UseNumber()
+				src, err := decodeObject(dec)
+				if err == nil && dec.More() {
+					err = errors.New("unexpected data after the object")
+				}
+				// A malformed line only affects its own row.
+				var re rowError
+				if err != nil && !errors.As(err, &re) {
+					err = rowError{err}
+				}
+				return src, err
+			}
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %d", format)
+}
+
+// decodeObject decodes the next JSON value of dec, which must be an object.
+func decodeObject(dec *json.Decoder) (map[string]interface{}, error) {
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	src, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, rowError{fmt.Errorf("expected an object, got %T", v)}
+	}
+	return src, nil
+}
+
+// mapRow splits a source row into key and columns as given by mapping.
+func mapRow(src map[string]interface{}, mapping LoadMapping) (map[string]interface{}, map[string]interface{}, error) {
+	name := func(field string) string {
+		if n, ok := mapping.Names[field]; ok {
+			return n
+		}
+		return field
+	}
+	key := make(map[string]interface{}, len(mapping.Key))
+	isKey := make(map[string]bool, len(mapping.Key))
+	for _, field := range mapping.Key {
+		v, ok := src[field]
+		if !ok || v == nil || v == "" {
+			return nil, nil, fmt.Errorf("missing key field %q", field)
+		}
+		c, err := coerce(v, mapping.Types[field])
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %q: %v", field, err)
+		} else if c == nil {
+			return nil, nil, fmt.Errorf("missing key field %q", field)
+		}
+		key[name(field)] = c
+		isKey[field] = true
+	}
+	fields := mapping.Columns
+	if len(fields) == 0 {
+		for field := range src {
+			if !isKey[field] {
+				fields = append(fields, field)
+			}
+		}
+	}
+	columns := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		v, ok := src[field]
+		if !ok {
+			continue
+		}
+		c, err := coerce(v, mapping.Types[field])
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %q: %v", field, err)
+		} else if c == nil && v != nil {
+			continue
+		}
+		columns[name(field)] = c
+	}
+	return key, columns, nil
+}
+
+// coerce converts a source value to one of the types written by fixValue.
+// Empty strings coerce to nil for every type but AutoType and StringType,
+// and such columns are left out of the row.
+func coerce(v interface{}, typ int) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	str, isStr := v.(string)
+	if isStr && str == "" && typ != AutoType && typ != StringType {
+		return nil, nil
+	}
+	switch typ {
+	case AutoType:
+		return autoValue(v), nil
+	case StringType:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case IntType:
+		switch v := v.(type) {
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		case json.Number:
+			return v.Int64()
+		}
+	case DoubleType:
+		switch v := v.(type) {
+		case string:
+			return strconv.ParseFloat(v, 64)
+		case json.Number:
+			return v.Float64()
+		}
+	case BooleanType:
+		switch v := v.(type) {
+		case string:
+			return strconv.ParseBool(v)
+		case bool:
+			return v, nil
+		}
+	case BinaryType:
+		if isStr {
+			return base64.StdEncoding.DecodeString(str)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %d", typ)
+	}
+	return nil, fmt.Errorf("cannot coerce %T", v)
+}
+
+// autoValue converts the JSON numbers of v to int64 when integral and
+// float64 otherwise.
+func autoValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, e := range v {
+			v[i] = autoValue(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = autoValue(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,52 @@
+package pundun
+
+// followPages reads a range page by page, starting from from, and calls
+// yield with each element until it returns false. At most n elements
+// are read when n is not negative. fetch reads up to count elements and
+// returns the continuation key of the next page, if any. Not all nodes
+// send a continuation, so a full page without one is followed from its
+// last key. An element repeating the last key read at the start of a
+// page is skipped, so fetch may treat the continuation either as the
+// last key read or as the next key to read.
+func followPages[T, K any](from K, n, limit int, fetch func(from K, count int) ([]T, K, bool, error),
+	keyOf func(T) K, same func(a, b K) bool, yield func(T) bool) error {
+	var last K
+	read := false
+	left := n
+	for left != 0 {
+		count := limit
+		if left > 0 && left < count {
+			count = left
+		}
+		list, cont, ok, err := fetch(from, count)
+		if err != nil {
+			return err
+		}
+		full := len(list) >= count
+		if read && len(list) > 0 && same(keyOf(list[0]), last) {
+			list = list[1:]
+		}
+		for _, e := range list {
+			if !yield(e) {
+				return nil
+			}
+			last, read = keyOf(e), true
+			if left > 0 {
+				if left--; left == 0 {
+					return nil
+				}
+			}
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		if !ok {
+			if !full {
+				return nil
+			}
+			cont = last
+		}
+		from = cont
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+//go:build go1.23
+
+package pundun
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ParallelScanOptions controls ParallelScan.
+type ParallelScanOptions struct {
+	// Concurrency bounds the number of sub-ranges read at the same time.
+	// Defaults to 4.
+	Concurrency int
+	// Parts is the number of sub-ranges the range is split into. Defaults
+	// to the table's number of shards, or Concurrency if unknown.
+	Parts int
+	// Splits, when given, are the keys where the range is split instead
+	// of splitting it automatically. They must be ordered from start to
+	// end by the table's comparator.
+	Splits []map[string]interface{}
+	// Ordered delivers results in key order. Otherwise results are
+	// delivered as soon as they are read.
+	Ordered bool
+}
+
+// ParallelScan reads every key from start to end by running concurrent
+// ReadRange calls over sub-ranges on the session's connection, and calls
+// fn for each key columns pair. fn is never called concurrently. The
+// scan stops with the first error returned by fn or by a read.
+//
+// Unless Splits are given, the range is split by interpolating the
+// first key field of start and end, which must both be integers,
+// doubles or strings; other ranges are read as a single part.
+func ParallelScan(ctx context.Context, s Session, tableName string, start, end map[string]interface{},
+	opts ParallelScanOptions, fn func(KVP) error) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	bounds := opts.Splits
+	if bounds == nil {
+		info, err := DescribeTableContext(ctx, s, tableName, []string{"key", "num_of_shards"})
+		if err != nil {
+			return err
+		}
+		parts := opts.Parts
+		if parts <= 0 {
+			parts = info.NumOfShards
+		}
+		if parts <= 0 {
+			parts = opts.Concurrency
+		}
+		bounds = splitRange(info.Key, start, end, parts)
+	}
+	bounds = append(append([]map[string]interface{}{start}, bounds...), end)
+	// Keys are compared with the keys read, which hold decoded values.
+	for i, b := range bounds {
+		if b != nil {
+			bounds[i] = formatFields(fixFields(b))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := len(bounds) - 1
+	outs := make([]chan KVP, n)
+	errs := make(chan error, n)
+	var merged chan KVP
+	if opts.Ordered {
+		for i := range outs {
+			outs[i] = make(chan KVP, scanBatch)
+		}
+	} else {
+		merged = make(chan KVP, scanBatch)
+	}
+	var wg sync.WaitGroup
+	go func() {
+		slots := make(chan struct{}, opts.Concurrency)
+		for i := 0; i < n; i++ {
+			out := merged
+			if opts.Ordered {
+				out = outs[i]
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				if opts.Ordered {
+					close(out)
+				}
+				continue
+			}
+			wg.Add(1)
+			go func(i int, out chan KVP) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if opts.Ordered {
+					defer close(out)
+				}
+				if err := scanPart(ctx, s, tableName, bounds[i], bounds[i+1], i == n-1, out); err != nil {
+					errs <- err
+					cancel()
+				}
+			}(i, out)
+		}
+		wg.Wait()
+		if !opts.Ordered {
+			close(merged)
+		}
+	}()
+
+	var fnErr error
+	deliver := func(in chan KVP) {
+		for kvp := range in {
+			if fnErr == nil {
+				if fnErr = fn(kvp); fnErr != nil {
+					cancel()
+				}
+			}
+		}
+	}
+	if opts.Ordered {
+		for _, out := range outs {
+			deliver(out)
+		}
+	} else {
+		deliver(merged)
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// ParallelScanChan is like ParallelScan but delivers the key columns
+// pairs on a channel. The error channel receives the result of the scan
+// once the pairs channel is closed.
+func ParallelScanChan(ctx context.Context, s Session, tableName string, start, end map[string]interface{},
+	opts ParallelScanOptions) (<-chan KVP, <-chan error) {
+	kvps := make(chan KVP, scanBatch)
+	errc := make(chan error, 1)
+	go func() {
+		err := ParallelScan(ctx, s, tableName, start, end, opts, func(kvp KVP) error {
+			select {
+			case kvps <- kvp:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(kvps)
+		errc <- err
+	}()
+	return kvps, errc
+}
+
+// scanPart reads the sub-range from start to end into out. Unless last
+// is set, end itself belongs to the following part and is skipped.
+func scanPart(ctx context.Context, s Session, tableName string, start, end map[string]interface{},
+	last bool, out chan KVP) error {
+	for kvp, err := range ScanContext(ctx, s, tableName, start, end) {
+		if err != nil {
+			return err
+		}
+		if !last && reflect.DeepEqual(kvp.Key, end) {
+			return nil
+		}
+		select {
+		case out <- kvp:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// splitRange returns up to parts-1 keys between start and end, made by
+// interpolating the first field of keyDef and keeping the other fields
+// of start. It returns nil when the range cannot be split.
+func splitRange(keyDef []string, start, end map[string]interface{}, parts int) []map[string]interface{} {
+	if len(keyDef) == 0 || parts < 2 {
+		return nil
+	}
+	field := keyDef[0]
+	var lo, hi interface{}
+	var values []interface{}
+	switch a := start[field].(type) {
+	case int, int32, int64:
+		x, _ := toInt64(a)
+		y, ok := toInt64(end[field])
+		if !ok {
+			return nil
+		}
+		lo, hi = x, y
+		for i := 1; i < parts; i++ {
+			values = append(values, x+int64(float64(y-x)*float64(i)/float64(parts)))
+		}
+	case float64:
+		b, ok := end[field].(float64)
+		if !ok {
+			return nil
+		}
+		lo, hi = a, b
+		for i := 1; i < parts; i++ {
+			values = append(values, a+(b-a)*float64(i)/float64(parts))
+		}
+	case string:
+		b, ok := end[field].(string)
+		if !ok {
+			return nil
+		}
+		lo, hi = a, b
+		values = splitStrings(a, b, parts)
+	default:
+		return nil
+	}
+	splits := make([]map[string]interface{}, 0, len(values))
+	prev := lo
+	for _, v := range values {
+		if v == prev || v == hi {
+			continue
+		}
+		key := make(map[string]interface{}, len(start))
+		for k, e := range start {
+			key[k] = e
+		}
+		key[field] = v
+		splits = append(splits, key)
+		prev = v
+	}
+	return splits
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch i := v.(type) {
+	case int:
+		return int64(i), true
+	case int32:
+		return int64(i), true
+	case int64:
+		return i, true
+	}
+	return 0, false
+}
+
+// Interpolated string keys are made of printable ASCII only, so that
+// they are valid UTF-8 and can be sent as string values.
+const (
+	splitMin    = 0x20
+	splitDigits = 0x7f - splitMin
+	splitLen    = 8
+)
+
+// splitStrings interpolates the characters following the common prefix
+// of a and b, read as numbers in base splitDigits. Values that do not
+// fall strictly between a and b are left out.
+func splitStrings(a, b string, parts int) []interface{} {
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	prefix := a[:p]
+	if !utf8.ValidString(prefix) {
+		return nil
+	}
+	number := func(s string) uint64 {
+		var n uint64
+		for i := 0; i < splitLen; i++ {
+			var c byte = splitMin
+			if p+i < len(s) {
+				c = s[p+i]
+			}
+			if c < splitMin {
+				c = splitMin
+			} else if c >= splitMin+splitDigits {
+				c = splitMin + splitDigits - 1
+			}
+			n = n*splitDigits + uint64(c-splitMin)
+		}
+		return n
+	}
+	x, y := number(a), number(b)
+	values := make([]interface{}, 0, parts-1)
+	for i := 1; i < parts; i++ {
+		var v uint64
+		if y >= x {
+			v = x + uint64(float64(y-x)*float64(i)/float64(parts))
+		} else {
+			v = x - uint64(float64(x-y)*float64(i)/float64(parts))
+		}
+		buf := make([]byte, splitLen)
+		for j := splitLen - 1; j >= 0; j-- {
+			buf[j] = splitMin + byte(v%splitDigits)
+			v /= splitDigits
+		}
+		split := prefix + strings.TrimRight(string(buf), " ")
+		if a < split && split < b || b < split && split < a {
+			values = append(values, split)
+		}
+	}
+	return values
+}
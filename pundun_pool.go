@@ -0,0 +1,686 @@
+package pundun
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancing strategies for a Pool.
+const (
+	RoundRobin    = 0
+	LeastInFlight = 1
+)
+
+// ErrNoHealthyNodes is returned by a Pool when every node is marked unhealthy.
+var ErrNoHealthyNodes = errors.New("pundun: no healthy nodes")
+
+// ErrUnknownIterator is returned by a Pool's Next and Prev for an
+// iterator it did not create or has released.
+var ErrUnknownIterator = errors.New("pundun: iterator not created by this pool")
+
+// PoolConfig holds the settings used by NewPool.
+type PoolConfig struct {
+	// Config is used for every session opened by the pool.
+	Config Config
+	// SessionsPerNode is the number of sessions kept open to each node.
+	// Defaults to 1.
+	SessionsPerNode int
+	// Balance selects the balancing strategy, RoundRobin or LeastInFlight.
+	Balance int
+	// RetryAfter is how long a node stays unhealthy after a transport
+	// failure before it is tried again. Defaults to 5 seconds.
+	RetryAfter time.Duration
+}
+
+// Pool balances procedure calls over authenticated sessions to several
+// pundun nodes. Nodes failing with transport errors are marked unhealthy
+// and routed around until RetryAfter has passed. Reads, range reads,
+// index reads, TableInfo and ListTables failing that way are retried on
+// another node.
+//
+// Iterators returned by First, Last and Seek are bound to the session
+// that created them, so Next and Prev are sent on that session. An
+// iterator is released once it reaches the end of the table or its
+// session is closed.
+type Pool struct {
+	user      string
+	pass      string
+	conf      PoolConfig
+	mu        sync.Mutex
+	nodes     []*poolNode
+	next      uint32
+	closed    bool
+	iterators map[string]Session
+}
+
+type poolNode struct {
+	host       string
+	sessions   []Session
+	inFlight   []*int32
+	downUntil  time.Time
+	connecting bool
+}
+
+// NewPool connects to every seed address. Nodes that cannot be reached
+// are marked unhealthy; an error is returned only if no node is reachable.
+func NewPool(seeds []string, user string, pass string, conf PoolConfig) (*Pool, error) {
+	if conf.SessionsPerNode <= 0 {
+		conf.SessionsPerNode = 1
+	}
+	if conf.RetryAfter <= 0 {
+		conf.RetryAfter = 5 * time.Second
+	}
+	p := &Pool{user: user, pass: pass, conf: conf}
+	var lastErr error
+	healthy := 0
+	for _, host := range seeds {
+		n := &poolNode{host: host}
+		sessions, err := p.dial(host, conf.SessionsPerNode)
+		if err != nil {
+			lastErr = err
+			n.downUntil = time.Now().Add(conf.RetryAfter)
+		} else {
+			n.sessions = sessions
+			for range sessions {
+				n.inFlight = append(n.inFlight, new(int32))
+			}
+			healthy++
+		}
+		p.nodes = append(p.nodes, n)
+	}
+	if healthy == 0 {
+		p.Close()
+		if lastErr == nil {
+			lastErr = ErrNoHealthyNodes
+		}
+		return nil, lastErr
+	}
+	return p, nil
+}
+
+// Close disconnects every session of the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.iterators = nil
+	for _, n := range p.nodes {
+		n.disconnect()
+	}
+}
+
+// Session picks a healthy session from the pool.
+func (p *Pool) Session() (Session, error) {
+	_, s, _, err := p.pick(nil)
+	return s, err
+}
+
+// dial opens count sessions to host. If one fails, the ones already
+// opened are disconnected.
+func (p *Pool) dial(host string, count int) ([]Session, error) {
+	sessions := make([]Session, 0, count)
+	for i := 0; i < count; i++ {
+		s, err := ConnectWithConfig(host, p.user, p.pass, p.conf.Config)
+		if err != nil {
+			for _, s := range sessions {
+				Disconnect(s)
+			}
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// reconnect replaces the closed sessions of n. The sessions are dialed
+// without holding the pool's lock, so calls to other nodes are not held
+// up by a slow node; calls in the meantime skip n.
+func (p *Pool) reconnect(n *poolNode) {
+	p.mu.Lock()
+	if p.closed || n.connecting || time.Now().Before(n.downUntil) || n.ready() {
+		p.mu.Unlock()
+		return
+	}
+	n.connecting = true
+	var missing []int
+	for i := 0; i < p.conf.SessionsPerNode; i++ {
+		if i >= len(n.sessions) || isClosed(n.sessions[i]) {
+			missing = append(missing, i)
+		}
+	}
+	p.mu.Unlock()
+
+	sessions, err := p.dial(n.host, len(missing))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n.connecting = false
+	if err != nil {
+		n.downUntil = time.Now().Add(p.conf.RetryAfter)
+		return
+	}
+	if p.closed {
+		for _, s := range sessions {
+			Disconnect(s)
+		}
+		return
+	}
+	for j, i := range missing {
+		if i < len(n.sessions) {
+			n.sessions[i] = sessions[j]
+			n.inFlight[i] = new(int32)
+		} else {
+			n.sessions = append(n.sessions, sessions[j])
+			n.inFlight = append(n.inFlight, new(int32))
+		}
+	}
+}
+
+func (n *poolNode) disconnect() {
+	for _, s := range n.sessions {
+		if !isClosed(s) {
+			Disconnect(s)
+		}
+	}
+	n.sessions = nil
+	n.inFlight = nil
+}
+
+func (n *poolNode) ready() bool {
+	if len(n.sessions) == 0 {
+		return false
+	}
+	for _, s := range n.sessions {
+		if isClosed(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// pick selects a node and session index according to the balancing
+// strategy, leaving out the nodes in exclude. Unhealthy nodes whose
+// RetryAfter has passed are reconnected first.
+func (p *Pool) pick(exclude []*poolNode) (*poolNode, Session, *int32, error) {
+	p.mu.Lock()
+	now := time.Now()
+	var stale []*poolNode
+	for _, n := range p.nodes {
+		if !now.Before(n.downUntil) && !n.connecting && !n.ready() {
+			stale = append(stale, n)
+		}
+	}
+	p.mu.Unlock()
+	for _, n := range stale {
+		p.reconnect(n)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now = time.Now()
+	var candidates []*poolNode
+	for _, n := range p.nodes {
+		if now.Before(n.downUntil) || n.connecting || !n.ready() || containsNode(exclude, n) {
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	if len(candidates) == 0 {
+		return nil, Session{}, nil, ErrNoHealthyNodes
+	}
+	var best *poolNode
+	idx := 0
+	switch p.conf.Balance {
+	case LeastInFlight:
+		var bestLoad int32 = -1
+		for _, n := range candidates {
+			for i := range n.sessions {
+				load := atomic.LoadInt32(n.inFlight[i])
+				if bestLoad < 0 || load < bestLoad {
+					best, idx, bestLoad = n, i, load
+				}
+			}
+		}
+	default:
+		k := int(p.next)
+		p.next++
+		best = candidates[k%len(candidates)]
+		idx = (k / len(candidates)) % len(best.sessions)
+	}
+	return best, best.sessions[idx], best.inFlight[idx], nil
+}
+
+func containsNode(nodes []*poolNode, n *poolNode) bool {
+	for _, m := range nodes {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// markDown flags n unhealthy until RetryAfter has passed.
+func (p *Pool) markDown(n *poolNode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n.downUntil = time.Now().Add(p.conf.RetryAfter)
+}
+
+// do runs f on a picked session and tracks in-flight calls and health.
+// When the node fails and the procedure is idempotent, f is retried on
+// the other healthy nodes.
+func (p *Pool) do(idempotent bool, f func(s Session) error) error {
+	var tried []*poolNode
+	var lastErr error
+	for {
+		n, s, counter, err := p.pick(tried)
+		if err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+		atomic.AddInt32(counter, 1)
+		err = f(s)
+		atomic.AddInt32(counter, -1)
+		if !isTransportFailure(err) {
+			return err
+		}
+		p.markDown(n)
+		if !idempotent {
+			return err
+		}
+		tried = append(tried, n)
+		lastErr = err
+	}
+}
+
+// iterate runs f, which creates an iterator, like do and pins the
+// iterator to the session it was created on.
+func (p *Pool) iterate(f func(s Session) (Iterator, error)) (Iterator, error) {
+	var it Iterator
+	var session Session
+	err := p.do(true, func(s Session) (err error) {
+		it, err = f(s)
+		session = s
+		return err
+	})
+	if err == nil && it.It != nil {
+		p.pin(it.It, session)
+	}
+	return it, err
+}
+
+// pin binds it to s, releasing the iterators of closed sessions.
+func (p *Pool) pin(it []byte, s Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if p.iterators == nil {
+		p.iterators = make(map[string]Session)
+	}
+	for k, ps := range p.iterators {
+		if isClosed(ps) {
+			delete(p.iterators, k)
+		}
+	}
+	p.iterators[string(it)] = s
+}
+
+// step runs f on the session pinned to it. The iterator is released
+// when it reaches the end of the table or its session fails.
+func (p *Pool) step(it []byte, f func(s Session) error) error {
+	p.mu.Lock()
+	s, ok := p.iterators[string(it)]
+	p.mu.Unlock()
+	if !ok {
+		return ErrUnknownIterator
+	}
+	err := f(s)
+	if errors.Is(err, ErrEndOfTable) || isTransportFailure(err) {
+		p.mu.Lock()
+		delete(p.iterators, string(it))
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// isTransportFailure reports whether err means the node or the
+// connection to it is not usable. A timeout is not one: it may just be
+// the caller's deadline for a slow call.
+func isTransportFailure(err error) bool {
+	var t *TransportError
+	return errors.As(err, &t) ||
+		errors.Is(err, ErrSessionClosed) ||
+		errors.Is(err, ErrConnectionLost)
+}
+
+// CreateTable is the pool counterpart of the CreateTable procedure.
+func (p *Pool) CreateTable(tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	return p.CreateTableContext(context.Background(), tableName, key, options)
+}
+
+// CreateTableContext is the pool counterpart of CreateTableContext.
+func (p *Pool) CreateTableContext(ctx context.Context, tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = CreateTableContext(ctx, s, tableName, key, options)
+		return err
+	})
+	return res, err
+}
+
+// CreateTableWithOptions is the pool counterpart of the
+// CreateTableWithOptions procedure.
+func (p *Pool) CreateTableWithOptions(tableName string, key []string, opts TableOptions) (interface{}, error) {
+	return p.CreateTableWithOptionsContext(context.Background(), tableName, key, opts)
+}
+
+// CreateTableWithOptionsContext is the pool counterpart of
+// CreateTableWithOptionsContext.
+func (p *Pool) CreateTableWithOptionsContext(ctx context.Context, tableName string, key []string, opts TableOptions) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = CreateTableWithOptionsContext(ctx, s, tableName, key, opts)
+		return err
+	})
+	return res, err
+}
+
+// DeleteTable is the pool counterpart of the DeleteTable procedure.
+func (p *Pool) DeleteTable(tableName string) (interface{}, error) {
+	return p.DeleteTableContext(context.Background(), tableName)
+}
+
+// DeleteTableContext is the pool counterpart of DeleteTableContext.
+func (p *Pool) DeleteTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = DeleteTableContext(ctx, s, tableName)
+		return err
+	})
+	return res, err
+}
+
+// OpenTable is the pool counterpart of the OpenTable procedure.
+func (p *Pool) OpenTable(tableName string) (interface{}, error) {
+	return p.OpenTableContext(context.Background(), tableName)
+}
+
+// OpenTableContext is the pool counterpart of OpenTableContext.
+func (p *Pool) OpenTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = OpenTableContext(ctx, s, tableName)
+		return err
+	})
+	return res, err
+}
+
+// CloseTable is the pool counterpart of the CloseTable procedure.
+func (p *Pool) CloseTable(tableName string) (interface{}, error) {
+	return p.CloseTableContext(context.Background(), tableName)
+}
+
+// CloseTableContext is the pool counterpart of CloseTableContext.
+func (p *Pool) CloseTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = CloseTableContext(ctx, s, tableName)
+		return err
+	})
+	return res, err
+}
+
+// TableInfo is the pool counterpart of the TableInfo procedure.
+func (p *Pool) TableInfo(tableName string, attrs []string) (interface{}, error) {
+	return p.TableInfoContext(context.Background(), tableName, attrs)
+}
+
+// TableInfoContext is the pool counterpart of TableInfoContext.
+func (p *Pool) TableInfoContext(ctx context.Context, tableName string, attrs []string) (interface{}, error) {
+	var res interface{}
+	err := p.do(true, func(s Session) (err error) {
+		res, err = TableInfoContext(ctx, s, tableName, attrs)
+		return err
+	})
+	return res, err
+}
+
+// Read is the pool counterpart of the Read procedure.
+func (p *Pool) Read(tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	return p.ReadContext(context.Background(), tableName, key)
+}
+
+// ReadContext is the pool counterpart of ReadContext.
+func (p *Pool) ReadContext(ctx context.Context, tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	var res map[string]interface{}
+	err := p.do(true, func(s Session) (err error) {
+		res, err = ReadContext(ctx, s, tableName, key)
+		return err
+	})
+	return res, err
+}
+
+// Write is the pool counterpart of the Write procedure.
+func (p *Pool) Write(tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	return p.WriteContext(context.Background(), tableName, key, columns)
+}
+
+// WriteContext is the pool counterpart of WriteContext.
+func (p *Pool) WriteContext(ctx context.Context, tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = WriteContext(ctx, s, tableName, key, columns)
+		return err
+	})
+	return res, err
+}
+
+// Update is the pool counterpart of the Update procedure.
+func (p *Pool) Update(tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
+	return p.UpdateContext(context.Background(), tableName, key, upOps)
+}
+
+// UpdateContext is the pool counterpart of UpdateContext.
+func (p *Pool) UpdateContext(ctx context.Context, tableName string, key map[string]interface{}, upOps []UpdateOperation) (map[string]interface{}, error) {
+	var res map[string]interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = UpdateContext(ctx, s, tableName, key, upOps)
+		return err
+	})
+	return res, err
+}
+
+// Delete is the pool counterpart of the Delete procedure.
+func (p *Pool) Delete(tableName string, key map[string]interface{}) (interface{}, error) {
+	return p.DeleteContext(context.Background(), tableName, key)
+}
+
+// DeleteContext is the pool counterpart of DeleteContext.
+func (p *Pool) DeleteContext(ctx context.Context, tableName string, key map[string]interface{}) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = DeleteContext(ctx, s, tableName, key)
+		return err
+	})
+	return res, err
+}
+
+// ReadRange is the pool counterpart of the ReadRange procedure.
+func (p *Pool) ReadRange(tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
+	return p.ReadRangeContext(context.Background(), tableName, skey, ekey, limit)
+}
+
+// ReadRangeContext is the pool counterpart of ReadRangeContext.
+func (p *Pool) ReadRangeContext(ctx context.Context, tableName string, skey, ekey map[string]interface{}, limit int) (KVL, error) {
+	var res KVL
+	err := p.do(true, func(s Session) (err error) {
+		res, err = ReadRangeContext(ctx, s, tableName, skey, ekey, limit)
+		return err
+	})
+	return res, err
+}
+
+// ReadRangeN is the pool counterpart of the ReadRangeN procedure.
+func (p *Pool) ReadRangeN(tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return p.ReadRangeNContext(context.Background(), tableName, skey, n)
+}
+
+// ReadRangeNContext is the pool counterpart of ReadRangeNContext.
+func (p *Pool) ReadRangeNContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	var res KVL
+	err := p.do(true, func(s Session) (err error) {
+		res, err = ReadRangeNContext(ctx, s, tableName, skey, n)
+		return err
+	})
+	return res, err
+}
+
+// ReadRangeNTs is the pool counterpart of the ReadRangeNTs procedure.
+func (p *Pool) ReadRangeNTs(tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	return p.ReadRangeNTsContext(context.Background(), tableName, skey, n)
+}
+
+// ReadRangeNTsContext is the pool counterpart of ReadRangeNTsContext.
+func (p *Pool) ReadRangeNTsContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (KVL, error) {
+	var res KVL
+	err := p.do(true, func(s Session) (err error) {
+		res, err = ReadRangeNTsContext(ctx, s, tableName, skey, n)
+		return err
+	})
+	return res, err
+}
+
+// First is the pool counterpart of the First procedure.
+func (p *Pool) First(tableName string) (Iterator, error) {
+	return p.FirstContext(context.Background(), tableName)
+}
+
+// FirstContext is the pool counterpart of FirstContext.
+func (p *Pool) FirstContext(ctx context.Context, tableName string) (Iterator, error) {
+	return p.iterate(func(s Session) (Iterator, error) {
+		return FirstContext(ctx, s, tableName)
+	})
+}
+
+// Last is the pool counterpart of the Last procedure.
+func (p *Pool) Last(tableName string) (Iterator, error) {
+	return p.LastContext(context.Background(), tableName)
+}
+
+// LastContext is the pool counterpart of LastContext.
+func (p *Pool) LastContext(ctx context.Context, tableName string) (Iterator, error) {
+	return p.iterate(func(s Session) (Iterator, error) {
+		return LastContext(ctx, s, tableName)
+	})
+}
+
+// Seek is the pool counterpart of the Seek procedure.
+func (p *Pool) Seek(tableName string, key map[string]interface{}) (Iterator, error) {
+	return p.SeekContext(context.Background(), tableName, key)
+}
+
+// SeekContext is the pool counterpart of SeekContext.
+func (p *Pool) SeekContext(ctx context.Context, tableName string, key map[string]interface{}) (Iterator, error) {
+	return p.iterate(func(s Session) (Iterator, error) {
+		return SeekContext(ctx, s, tableName, key)
+	})
+}
+
+// Next is the pool counterpart of the Next procedure. It is sent on the
+// session that created it.
+func (p *Pool) Next(it []byte) (KVP, error) {
+	return p.NextContext(context.Background(), it)
+}
+
+// NextContext is the pool counterpart of NextContext.
+func (p *Pool) NextContext(ctx context.Context, it []byte) (KVP, error) {
+	var res KVP
+	err := p.step(it, func(s Session) (err error) {
+		res, err = NextContext(ctx, s, it)
+		return err
+	})
+	return res, err
+}
+
+// Prev is the pool counterpart of the Prev procedure. It is sent on the
+// session that created it.
+func (p *Pool) Prev(it []byte) (interface{}, error) {
+	return p.PrevContext(context.Background(), it)
+}
+
+// PrevContext is the pool counterpart of PrevContext.
+func (p *Pool) PrevContext(ctx context.Context, it []byte) (interface{}, error) {
+	var res interface{}
+	err := p.step(it, func(s Session) (err error) {
+		res, err = PrevContext(ctx, s, it)
+		return err
+	})
+	return res, err
+}
+
+// AddIndex is the pool counterpart of the AddIndex procedure.
+func (p *Pool) AddIndex(tableName string, configList []IndexConfig) (interface{}, error) {
+	return p.AddIndexContext(context.Background(), tableName, configList)
+}
+
+// AddIndexContext is the pool counterpart of AddIndexContext.
+func (p *Pool) AddIndexContext(ctx context.Context, tableName string, configList []IndexConfig) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = AddIndexContext(ctx, s, tableName, configList)
+		return err
+	})
+	return res, err
+}
+
+// RemoveIndex is the pool counterpart of the RemoveIndex procedure.
+func (p *Pool) RemoveIndex(tableName string, columns []string) (interface{}, error) {
+	return p.RemoveIndexContext(context.Background(), tableName, columns)
+}
+
+// RemoveIndexContext is the pool counterpart of RemoveIndexContext.
+func (p *Pool) RemoveIndexContext(ctx context.Context, tableName string, columns []string) (interface{}, error) {
+	var res interface{}
+	err := p.do(false, func(s Session) (err error) {
+		res, err = RemoveIndexContext(ctx, s, tableName, columns)
+		return err
+	})
+	return res, err
+}
+
+// IndexRead is the pool counterpart of the IndexRead procedure.
+func (p *Pool) IndexRead(tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
+	return p.IndexReadContext(context.Background(), tableName, columnName, term, pf)
+}
+
+// IndexReadContext is the pool counterpart of IndexReadContext.
+func (p *Pool) IndexReadContext(ctx context.Context, tableName string, columnName string, term string, pf PostingFilter) (interface{}, error) {
+	var res interface{}
+	err := p.do(true, func(s Session) (err error) {
+		res, err = IndexReadContext(ctx, s, tableName, columnName, term, pf)
+		return err
+	})
+	return res, err
+}
+
+// ListTables is the pool counterpart of the ListTables procedure.
+func (p *Pool) ListTables() ([]string, error) {
+	return p.ListTablesContext(context.Background())
+}
+
+// ListTablesContext is the pool counterpart of ListTablesContext.
+func (p *Pool) ListTablesContext(ctx context.Context) ([]string, error) {
+	var res []string
+	err := p.do(true, func(s Session) (err error) {
+		res, err = ListTablesContext(ctx, s)
+		return err
+	})
+	return res, err
+}
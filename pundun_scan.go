@@ -0,0 +1,87 @@
+//go:build go1.23
+
+package pundun
+
+import (
+	"context"
+	"iter"
+	"reflect"
+)
+
+// Number of keys requested per ReadRange call by Scan.
+const (
+	scanBatch = 1000
+)
+
+// Scan yields every key columns pair from start to end, following the
+// continuation returned by ReadRange until the range is exhausted. The
+// first error is yielded with an empty KVP and ends the scan.
+func Scan(s Session, tableName string, start, end map[string]interface{}) iter.Seq2[KVP, error] {
+	return ScanContext(context.Background(), s, tableName, start, end)
+}
+
+// ScanContext is like Scan but stops with ctx.Err() as soon as ctx is done.
+func ScanContext(ctx context.Context, s Session, tableName string, start, end map[string]interface{}) iter.Seq2[KVP, error] {
+	fetch := func(from map[string]interface{}, count int) (KVL, error) {
+		return ReadRangeContext(ctx, s, tableName, from, end, count)
+	}
+	return scanPages(start, -1, fetch)
+}
+
+// ScanN yields at most n key columns pairs starting from start, following
+// the continuation returned by ReadRangeN.
+func ScanN(s Session, tableName string, start map[string]interface{}, n int) iter.Seq2[KVP, error] {
+	return ScanNContext(context.Background(), s, tableName, start, n)
+}
+
+// ScanNContext is like ScanN but stops with ctx.Err() as soon as ctx is done.
+func ScanNContext(ctx context.Context, s Session, tableName string, start map[string]interface{}, n int) iter.Seq2[KVP, error] {
+	fetch := func(from map[string]interface{}, count int) (KVL, error) {
+		return ReadRangeNContext(ctx, s, tableName, from, count)
+	}
+	return scanPages(start, n, fetch)
+}
+
+// ScanFrom yields every key columns pair from seekKey to the end of the
+// table using Seek and Next.
+func ScanFrom(s Session, tableName string, seekKey map[string]interface{}) iter.Seq2[KVP, error] {
+	return ScanFromContext(context.Background(), s, tableName, seekKey)
+}
+
+// ScanFromContext is like ScanFrom but stops with ctx.Err() as soon as ctx is done.
+func ScanFromContext(ctx context.Context, s Session, tableName string, seekKey map[string]interface{}) iter.Seq2[KVP, error] {
+	return func(yield func(KVP, error) bool) {
+		c := SeekCursorContext(ctx, s, tableName, seekKey)
+		defer c.Close()
+		for c.Next() {
+			if !yield(c.KVP(), nil) {
+				return
+			}
+		}
+		if err := c.Err(); err != nil {
+			yield(KVP{}, err)
+		}
+	}
+}
+
+// scanPages yields the pages of up to scanBatch pairs returned by fetch,
+// starting from start and followed as by followPages. At most n pairs
+// are yielded when n is not negative.
+func scanPages(start map[string]interface{}, n int, fetch func(from map[string]interface{}, count int) (KVL, error)) iter.Seq2[KVP, error] {
+	return func(yield func(KVP, error) bool) {
+		page := func(from map[string]interface{}, count int) ([]KVP, map[string]interface{}, bool, error) {
+			kvl, err := fetch(from, count)
+			return kvl.List, kvl.Continuation, len(kvl.Continuation) > 0, err
+		}
+		keyOf := func(kvp KVP) map[string]interface{} { return kvp.Key }
+		same := func(a, b map[string]interface{}) bool { return reflect.DeepEqual(a, b) }
+		stopped := false
+		err := followPages(start, n, scanBatch, page, keyOf, same, func(kvp KVP) bool {
+			stopped = !yield(kvp, nil)
+			return !stopped
+		})
+		if err != nil && !stopped {
+			yield(KVP{}, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+//go:build go1.23
+
+package pundun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/falkevik/pundun/punduntest"
+	"github.com/golang/protobuf/proto"
+	"github.com/pundunlabs/apollo"
+)
+
+func TestScanPages(t *testing.T) {
+	key := func(i int) map[string]interface{} {
+		return map[string]interface{}{"id": int64(i)}
+	}
+	// Pages of three keys out of ten, continuing with the last key read.
+	fetch := func(from map[string]interface{}, _ int) (KVL, error) {
+		first := int(from["id"].(int64))
+		var list []KVP
+		for i := first; i < first+3 && i < 10; i++ {
+			list = append(list, KVP{Key: key(i)})
+		}
+		var cont map[string]interface{}
+		if first+3 < 10 {
+			cont = key(first + 2)
+		}
+		return KVL{list, cont}, nil
+	}
+
+	var got []int64
+	for kvp, err := range scanPages(key(0), -1, fetch) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, kvp.Key["id"].(int64))
+	}
+	if len(got) != 10 || got[9] != 9 {
+		t.Fatalf("unexpected scan %v", got)
+	}
+
+	count := 0
+	for range scanPages(key(0), 4, fetch) {
+		count++
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 keys, got %v", count)
+	}
+
+	boom := errors.New("boom")
+	for _, err := range scanPages(key(0), -1, func(map[string]interface{}, int) (KVL, error) {
+		return KVL{}, boom
+	}) {
+		if err != boom {
+			t.Fatalf("expected boom, got %v", err)
+		}
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	keyDef := []string{"ts", "imsi"}
+	start := map[string]interface{}{"ts": int64(0), "imsi": "1"}
+	end := map[string]interface{}{"ts": int64(100), "imsi": "1"}
+	splits := splitRange(keyDef, start, end, 4)
+	if len(splits) != 3 || splits[0]["ts"] != int64(25) || splits[2]["ts"] != int64(75) || splits[1]["imsi"] != "1" {
+		t.Fatalf("unexpected splits %v", splits)
+	}
+
+	// Descending ranges split the same way.
+	splits = splitRange(keyDef, end, start, 2)
+	if len(splits) != 1 || splits[0]["ts"] != int64(50) {
+		t.Fatalf("unexpected splits %v", splits)
+	}
+
+	sstart := map[string]interface{}{"id": "user_a"}
+	send := map[string]interface{}{"id": "user_z"}
+	splits = splitRange([]string{"id"}, sstart, send, 2)
+	if len(splits) != 1 {
+		t.Fatalf("unexpected string splits %v", splits)
+	}
+	mid := splits[0]["id"].(string)
+	if mid <= "user_a" || mid >= "user_z" || !utf8.ValidString(mid) {
+		t.Fatalf("split %q outside range or invalid", mid)
+	}
+	for _, r := range [][2]string{{"user_a", "user_z"}, {"a", "b"}, {"\u00e9", "\u00e8"}, {"\x01", "\u00ff"}} {
+		for _, split := range splitRange([]string{"id"}, map[string]interface{}{"id": r[0]},
+			map[string]interface{}{"id": r[1]}, 8) {
+			id := split["id"].(string)
+			lo, hi := r[0], r[1]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if !utf8.ValidString(id) || id <= lo || id >= hi {
+				t.Fatalf("split %q of %q is outside range or invalid", id, r)
+			}
+			if _, err := proto.Marshal(fixValue(id)); err != nil {
+				t.Fatalf("split %q does not marshal: %v", id, err)
+			}
+		}
+	}
+
+	// Plain int keys split like int64 keys.
+	istart := map[string]interface{}{"ts": 0, "imsi": "1"}
+	iend := map[string]interface{}{"ts": int32(100), "imsi": "1"}
+	if splits := splitRange(keyDef, istart, iend, 4); len(splits) != 3 || splits[1]["ts"] != int64(50) {
+		t.Fatalf("unexpected int splits %v", splits)
+	}
+
+	if splits := splitRange(keyDef, map[string]interface{}{"ts": true}, end, 4); splits != nil {
+		t.Fatalf("expected no splits, got %v", splits)
+	}
+}
+
+func TestParallelScanSplits(t *testing.T) {
+	s := ConnectConn(punduntest.Pipe(punduntest.NewEngine()), Config{RequestTimeout: 5 * time.Second})
+	defer Disconnect(s)
+	if _, err := CreateTable(s, "ps", []string{"id"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for id := 0; id < 10; id++ {
+		if _, err := Write(s, "ps", map[string]interface{}{"id": id}, map[string]interface{}{"n": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Splits built with plain ints match the decoded boundary keys.
+	opts := ParallelScanOptions{Splits: []map[string]interface{}{{"id": 5}}, Ordered: true}
+	var got []int64
+	err := ParallelScan(context.Background(), s, "ps", map[string]interface{}{"id": 9},
+		map[string]interface{}{"id": 0}, opts, func(kvp KVP) error {
+			got = append(got, kvp.Key["id"].(int64))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 || got[0] != 9 || got[4] != 5 || got[5] != 4 {
+		t.Fatalf("unexpected scan %v", got)
+	}
+}
+
+func TestScanWithoutContinuation(t *testing.T) {
+	const total = 2*scanBatch + 500
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		// Pages start at the start key and carry no continuation.
+		p := pdu.GetReadRange()
+		var list []*apollo.KeyColumnsPair
+		from := int(formatFields(p.GetStartKey())["id"].(int64))
+		for id := from; id < total && id < from+int(p.GetLimit()); id++ {
+			list = append(list, &apollo.KeyColumnsPair{Key: fixFields(map[string]interface{}{"id": id})})
+		}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: &apollo.Response{
+			Result: &apollo.Response_KeyColumnsList{KeyColumnsList: &apollo.KeyColumnsList{List: list}},
+		}}}
+	})
+	defer Disconnect(s)
+
+	next := int64(0)
+	for kvp, err := range Scan(s, "t", map[string]interface{}{"id": 0}, map[string]interface{}{"id": total}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := kvp.Key["id"]; id != next {
+			t.Fatalf("expected id %d, got %v", next, id)
+		}
+		next++
+	}
+	if next != total {
+		t.Fatalf("read %d of %d rows", next, total)
+	}
+}
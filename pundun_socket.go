@@ -1,11 +1,15 @@
 package pundun
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"github.com/pundunlabs/go-scram"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"time"
 	"errors"
@@ -17,8 +21,11 @@ const (
 
 type Session struct {
 	manChan  chan int
-	sendChan chan Client
+	sendChan chan caller
 	tidChan  chan uint16
+	done     chan struct{}
+
+	requestTimeout time.Duration
 }
 
 func HSend(conn net.Conn, data []byte) (int, error) {
@@ -51,23 +58,178 @@ func HRead(conn net.Conn) ([]byte, error) {
 	return buf, nil
 }
 
-type Client struct {
+// Completion of a request. err is set when no response pdu was
+// received, e.g. ErrTimeout or ErrSessionClosed.
+type reply struct {
+	pdu []byte
+	err error
+}
+
+// caller is a request waiting for its response.
+type caller struct {
 	data        []byte
-	ch          chan []byte
+	ch          chan reply
 	id          uint16
 	cancelTimer chan bool
+	done        <-chan struct{}
+	deadline    time.Time
+}
+
+// Config holds the settings used by ConnectWithConfig.
+type Config struct {
+	// TLS is used to secure the connection. When nil, the node's
+	// certificate is verified against the system roots and the host name.
+	// Certificate verification is only skipped if InsecureSkipVerify is
+	// set explicitly.
+	TLS *tls.Config
+
+	// Reconnect enables the resilient session mode when set. A lost
+	// connection is then re-dialed and re-authenticated instead of
+	// closing the session.
+	Reconnect *ReconnectPolicy
+
+	// DialTimeout limits establishing the TCP and TLS connection.
+	// Zero means no limit.
+	DialTimeout time.Duration
+	// AuthTimeout limits the SCRAM authentication. Zero means no limit.
+	AuthTimeout time.Duration
+	// RequestTimeout is the default time a procedure call waits for its
+	// response. Defaults to 30 seconds. Use WithRequestTimeout to
+	// override it for a single call.
+	RequestTimeout time.Duration
+	// IdleTimeout closes the session after no request has been in flight
+	// for the given time. Zero keeps idle sessions open.
+	IdleTimeout time.Duration
+
+	// WrapConn, when set, wraps every authenticated connection before
+	// the session uses it, e.g. to record the traffic with a
+	// punduntest.Recorder.
+	WrapConn func(net.Conn) net.Conn
+}
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout returns a context that overrides the session's
+// request timeout for procedure calls made with it. Unlike a context
+// deadline, d may be longer than the session default.
+func WithRequestTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, d)
 }
 
+// ReconnectPolicy controls how a resilient session re-dials a lost
+// connection. Delays grow exponentially from InitialBackoff up to
+// MaxBackoff with random jitter. MaxAttempts limits the consecutive
+// failed attempts before the session is closed; zero means no limit.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxAttempts    int
+}
+
+// Connect to a pundun node without verifying its certificate.
+// Use ConnectWithConfig to verify the node's identity.
 func Connect(host string, user string, pass string) (Session, error) {
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
+	conf := Config{
+		TLS: &tls.Config{InsecureSkipVerify: true},
+	}
+	return ConnectWithConfig(host, user, pass, conf)
+}
+
+// ConnectWithConfig connects and authenticates to a pundun node using conf.
+func ConnectWithConfig(host string, user string, pass string, conf Config) (Session, error) {
+	tlsConf := conf.TLS
+	if tlsConf == nil {
+		tlsConf = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
 
-	conn, err := tls.Dial("tcp", host, conf)
+	conf.TLS = tlsConf
+	dial := func() (net.Conn, error) {
+		conn, err := dialNode(host, user, pass, conf)
+		if err == nil && conf.WrapConn != nil {
+			conn = conf.WrapConn(conn)
+		}
+		return conn, err
+	}
+	conn, err := dial()
 	if err != nil {
-		log.Println(err)
 		return Session{}, err
 	}
+	log.Println("Connected to pundun node.")
+	if conf.Reconnect == nil {
+		dial = nil
+	}
+	return startSession(conn, dial, conf), nil
+}
+
+// ConnectConn starts a session on conn, a connection that is already
+// established and authenticated, such as one returned by
+// punduntest.Pipe. The session is closed when conn is lost.
+func ConnectConn(conn net.Conn, conf Config) Session {
+	return startSession(conn, nil, conf)
+}
+
+// startSession serves a session over conn. dial is used to replace a
+// lost connection when not nil.
+func startSession(conn net.Conn, dial func() (net.Conn, error), conf Config) Session {
+	manChan := make(chan int, 1024)
+	sendChan := make(chan caller, 65535)
+
+	done := make(chan struct{})
+
+	go sessionLoop(conn, dial, conf.Reconnect, conf.IdleTimeout, manChan, sendChan, done)
+
+	tidChan := make(chan uint16, 1)
+	var tid uint16 = 0
+	go tidServer(tid, tidChan)
+
+	requestTimeout := conf.RequestTimeout
+	if requestTimeout <= 0 {
+		requestTimeout = timeout
+	}
+
+	return Session{manChan, sendChan, tidChan, done, requestTimeout}
+}
+
+// NewTLSConfig builds a verifying TLS configuration. caFile holds PEM
+// encoded root certificates trusted for the node; when empty the system
+// roots are used. certFile and keyFile, when given, are presented as
+// client certificate for mutual TLS.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+		conf.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// dialNode opens a TLS connection to host and runs SCRAM authentication.
+func dialNode(host string, user string, pass string, conf Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: conf.DialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, conf.TLS)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if conf.AuthTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(conf.AuthTimeout))
+		defer conn.SetDeadline(time.Time{})
+	}
 
 	scramc := struct { scram.ScramConn } {}
 	scramc.Send = func(data []byte) (int, error) { return HSend(conn, data) }
@@ -77,21 +239,74 @@ func Connect(host string, user string, pass string) (Session, error) {
 	if authErr != nil {
 		log.Println(authErr)
 		conn.Close()
-		return Session{}, authErr
+		return nil, authErr
 	}
-	log.Println("Connected to pundun node.")
-	manChan := make(chan int, 1024)
-	sendChan := make(chan Client, 65535)
-	recvChan := make(chan []byte, 65535)
+	return conn, nil
+}
 
-	go serverLoop(conn, manChan, sendChan, recvChan)
-	go recvLoop(conn, recvChan)
+// sessionLoop serves the session over conn. When the connection is lost
+// and dial is not nil, in-flight requests fail with ErrConnectionLost
+// and a new connection is dialed according to policy; otherwise the
+// session is closed.
+func sessionLoop(conn net.Conn, dial func() (net.Conn, error), policy *ReconnectPolicy,
+	idle time.Duration, manChan chan int, sendChan chan caller, done chan struct{}) {
+	defer close(done)
+	lost := ErrSessionClosed
+	if dial != nil {
+		lost = ErrConnectionLost
+	}
+	for {
+		recvChan := make(chan []byte, 65535)
+		go recvLoop(conn, recvChan)
+		if serverLoop(conn, manChan, sendChan, recvChan, lost, idle) || dial == nil {
+			return
+		}
+		conn = redial(dial, policy, manChan)
+		if conn == nil {
+			return
+		}
+		log.Println("Reconnected to pundun node.")
+	}
+}
 
-	tidChan := make(chan uint16, 1)
-	var tid uint16 = 0
-	go tidServer(tid, tidChan)
+// redial retries dial with exponential backoff and jitter. It returns nil
+// when the session is stopped or the policy's attempts are exhausted.
+func redial(dial func() (net.Conn, error), policy *ReconnectPolicy, manChan chan int) net.Conn {
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(jitter(backoff))
+		select {
+		case <-manChan:
+			timer.Stop()
+			log.Println("Stopping server loop")
+			return nil
+		case <-timer.C:
+		}
+		conn, err := dial()
+		if err == nil {
+			return conn
+		}
+		log.Printf("reconnect attempt %v failed: %v", attempt, err)
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return nil
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
 
-	return Session{manChan, sendChan, tidChan}, err
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
 }
 
 func Disconnect(s Session) {
@@ -99,46 +314,142 @@ func Disconnect(s Session) {
 	s.manChan <- stop
 }
 
+// isClosed reports whether the session has been disconnected or has
+// lost its connection for good.
+func isClosed(s Session) bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func GetTid(s Session) uint32 {
 	tid := <-s.tidChan
 	return uint32(tid)
 }
 
 func SendMsg(s Session, data []byte) []byte {
-	ch := make(chan []byte)
-	s.sendChan <- Client{data: data, ch: ch}
-	pdu := <-ch
+	pdu, _ := SendMsgContext(context.Background(), s, data)
 	return pdu
 }
 
-func serverLoop(conn net.Conn, manChan chan int, sendChan chan Client, recvChan chan []byte) {
+// SendMsgContext sends data and waits for the correlated response.
+// If ctx is done first, ctx.Err() is returned and the pending request
+// is dropped by the server loop, freeing its correlation id.
+// ErrTimeout, ErrSessionClosed or ErrTooManyRequests is returned when
+// the request completes without a response.
+func SendMsgContext(ctx context.Context, s Session, data []byte) ([]byte, error) {
+	c, err := enqueue(ctx, s, data)
+	if err != nil {
+		return nil, err
+	}
+	return awaitReply(ctx, s, c)
+}
+
+// enqueue hands data to the server loop, which sends requests in the
+// order they are enqueued, and returns the request whose reply is
+// awaited. The request timeout runs from here, so a request queued while
+// the session reconnects times out as well.
+func enqueue(ctx context.Context, s Session, data []byte) (caller, error) {
+	d := s.requestTimeout
+	if v, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok {
+		d = v
+	}
+	if d <= 0 {
+		d = timeout
+	}
+	c := caller{data: data, ch: make(chan reply, 1), done: ctx.Done(), deadline: time.Now().Add(d)}
+	select {
+	case s.sendChan <- c:
+		return c, nil
+	case <-s.done:
+		return caller{}, ErrSessionClosed
+	case <-ctx.Done():
+		return caller{}, ctx.Err()
+	}
+}
+
+// awaitReply waits for the reply of the enqueued request c.
+func awaitReply(ctx context.Context, s Session, c caller) ([]byte, error) {
+	timer := time.NewTimer(time.Until(c.deadline))
+	defer timer.Stop()
+	select {
+	case r := <-c.ch:
+		return r.pdu, r.err
+	case <-s.done:
+		// The loop may have completed the request right before exiting.
+		select {
+		case r := <-c.ch:
+			return r.pdu, r.err
+		default:
+			return nil, ErrSessionClosed
+		}
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// serverLoop multiplexes requests from sendChan over conn until the
+// session is stopped or idle for longer than idle, in which case it
+// returns true, or the connection is lost. Requests in flight on a lost
+// connection complete with lost.
+func serverLoop(conn net.Conn, manChan chan int, sendChan chan caller, recvChan chan []byte, lost error, idle time.Duration) bool {
 	var cid uint16 = 0
-	clients := make(map[uint16]Client)
+	clients := make(map[uint16]caller)
 	timeout := make(chan uint16, 65535)
-	defer close(sendChan)
-	defer close(timeout)
 	defer conn.Close()
+	var idleC <-chan time.Time
+	if idle > 0 {
+		idleTimer := time.NewTicker(idle)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+	active := false
 	for {
 		select {
+		case <-idleC:
+			if !active && len(clients) == 0 {
+				log.Println("Session idle, stopping server loop")
+				return true
+			}
+			active = false
 		case msg, _ := <-manChan:
 			switch msg {
 			case stop:
 				log.Println("Stopping server loop")
-				endClients(clients)
-				return
+				endClients(clients, reply{err: ErrSessionClosed})
+				return true
 			}
 		case toCid, _ := <-timeout:
-			removeClient(toCid, clients, []byte{})
-		case data, _ := <-recvChan:
+			removeClient(toCid, clients, reply{err: ErrTimeout})
+		case data, ok := <-recvChan:
+			if !ok {
+				log.Println("Connection lost, stopping server loop")
+				endClients(clients, reply{err: lost})
+				return false
+			}
+			if len(data) < 2 {
+				log.Printf("dropping short packet of %v bytes", len(data))
+				continue
+			}
 			len := len(data)
 			corrIdBytes := make([]byte, 2)
 			pduBytes := make([]byte, len-2)
 			copy(corrIdBytes, data[:2])
 			copy(pduBytes, data[2:])
 			corrId := binary.BigEndian.Uint16(corrIdBytes)
-			removeClient(corrId, clients, pduBytes)
+			removeClient(corrId, clients, reply{pdu: pduBytes})
+			active = true
 		case client, _ := <-sendChan:
-			if checkCorrId(clients, cid) {
+			remaining := time.Until(client.deadline)
+			if remaining <= 0 {
+				client.ch <- reply{err: ErrTimeout}
+				close(client.ch)
+			} else if checkCorrId(clients, cid) {
 				len := uint32(len(client.data))
 				header := make([]byte, 6)
 				binary.BigEndian.PutUint32(header, len+2)
@@ -149,17 +460,18 @@ func serverLoop(conn net.Conn, manChan chan int, sendChan chan Client, recvChan
 				clients[cid] = client
 				conn.Write(header)
 				conn.Write(client.data)
-				go expireAfter(30, cid, timeout, cancel)
+				go expireAfter(remaining, cid, timeout, cancel, client.done)
 				cid++
+				active = true
 			} else {
-				client.ch <- []byte{}
+				client.ch <- reply{err: ErrTooManyRequests}
 				close(client.ch)
 			}
 		}
 	}
 }
 
-func checkCorrId(clients map[uint16]Client, corrId uint16) bool {
+func checkCorrId(clients map[uint16]caller, corrId uint16) bool {
 	if _, ok := clients[corrId]; ok {
 		return false
 	} else {
@@ -198,34 +510,39 @@ func tidServer(tid uint16, tidChan chan uint16) {
 	}
 }
 
-func expireAfter(t int, cid uint16, timeout chan uint16, cancel chan bool) {
-	timer := time.NewTimer(time.Duration(t) * time.Second)
+func expireAfter(t time.Duration, cid uint16, expired chan uint16, cancel chan bool, done <-chan struct{}) {
+	if t <= 0 {
+		t = timeout
+	}
+	timer := time.NewTimer(t)
+	defer timer.Stop()
 	select {
 	case <-timer.C:
-		select {
-		case timeout <- cid:
-		default:
-		}
+	case <-done:
 	case <-cancel:
-		timer.Stop()
+		return
+	}
+	select {
+	case expired <- cid:
+	default:
 	}
 }
 
-func removeClient(cid uint16, clients map[uint16]Client, bytes []byte) {
+func removeClient(cid uint16, clients map[uint16]caller, r reply) {
 	if client, exists := clients[cid]; exists {
-		endClient(client, bytes)
+		endClient(client, r)
 		delete(clients, cid)
 	}
 }
 
-func endClients(clients map[uint16]Client) {
+func endClients(clients map[uint16]caller, r reply) {
 	for cid, client := range clients {
-		endClient(client, []byte{})
+		endClient(client, r)
 		delete(clients, cid)
 	}
 }
 
-func endClient(client Client, bytes []byte) {
+func endClient(client caller, r reply) {
 	defer close(client.cancelTimer)
 	defer close(client.ch)
 	select {
@@ -233,5 +550,5 @@ func endClient(client Client, bytes []byte) {
 	default:
 		//Do nothing
 	}
-	client.ch <- bytes
+	client.ch <- r
 }
@@ -0,0 +1,150 @@
+package pundun
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Table is a typed handle on a pundun table. K is the key type and V the
+// row type, both encoded as described for MarshalRow. K must be a struct
+// or a map with string keys; all its fields form the key of a row.
+// Fields of V tagged as key are filled from the key on reads.
+type Table[K, V any] struct {
+	s    Session
+	name string
+}
+
+// Row is a key and its decoded columns as returned by Table.Range.
+type Row[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// NewTable binds the table tableName on session s to the types K and V.
+func NewTable[K, V any](s Session, tableName string) *Table[K, V] {
+	return &Table[K, V]{s: s, name: tableName}
+}
+
+// Name returns the name of the table.
+func (t *Table[K, V]) Name() string {
+	return t.name
+}
+
+// Get the row stored under k.
+func (t *Table[K, V]) Get(k K) (V, error) {
+	return t.GetContext(context.Background(), k)
+}
+
+// GetContext is like Get but returns ctx.Err() as soon as ctx is done.
+func (t *Table[K, V]) GetContext(ctx context.Context, k K) (V, error) {
+	var v V
+	key, err := encodeKey(k)
+	if err != nil {
+		return v, err
+	}
+	columns, err := ReadContext(ctx, t.s, t.name, key)
+	if err != nil {
+		return v, err
+	}
+	err = decodeRow(KVP{key, columns}, reflect.ValueOf(&v).Elem())
+	return v, err
+}
+
+// Put stores v under k.
+func (t *Table[K, V]) Put(k K, v V) error {
+	return t.PutContext(context.Background(), k, v)
+}
+
+// PutContext is like Put but returns ctx.Err() as soon as ctx is done.
+func (t *Table[K, V]) PutContext(ctx context.Context, k K, v V) error {
+	key, err := encodeKey(k)
+	if err != nil {
+		return err
+	}
+	_, columns, err := MarshalRow(v)
+	if err != nil {
+		return err
+	}
+	_, err = WriteContext(ctx, t.s, t.name, key, columns)
+	return err
+}
+
+// Delete the row stored under k.
+func (t *Table[K, V]) Delete(k K) error {
+	return t.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext is like Delete but returns ctx.Err() as soon as ctx is done.
+func (t *Table[K, V]) DeleteContext(ctx context.Context, k K) error {
+	key, err := encodeKey(k)
+	if err != nil {
+		return err
+	}
+	_, err = DeleteContext(ctx, t.s, t.name, key)
+	return err
+}
+
+// Range reads at most limit rows from start to end.
+func (t *Table[K, V]) Range(start, end K, limit int) ([]Row[K, V], error) {
+	return t.RangeContext(context.Background(), start, end, limit)
+}
+
+// RangeContext is like Range but returns ctx.Err() as soon as ctx is done.
+func (t *Table[K, V]) RangeContext(ctx context.Context, start, end K, limit int) ([]Row[K, V], error) {
+	skey, err := encodeKey(start)
+	if err != nil {
+		return nil, err
+	}
+	ekey, err := encodeKey(end)
+	if err != nil {
+		return nil, err
+	}
+	kvl, err := ReadRangeContext(ctx, t.s, t.name, skey, ekey, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]Row[K, V], len(kvl.List))
+	for i, kvp := range kvl.List {
+		if err := decodeValue(kvp.Key, reflect.ValueOf(&rows[i].Key).Elem()); err != nil {
+			return nil, fmt.Errorf("pundun: key: %v", err)
+		}
+		if err := decodeRow(kvp, reflect.ValueOf(&rows[i].Value).Elem()); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// Update the row stored under k and return its updated columns.
+func (t *Table[K, V]) Update(k K, ops ...UpdateOperation) (V, error) {
+	return t.UpdateContext(context.Background(), k, ops...)
+}
+
+// UpdateContext is like Update but returns ctx.Err() as soon as ctx is done.
+func (t *Table[K, V]) UpdateContext(ctx context.Context, k K, ops ...UpdateOperation) (V, error) {
+	var v V
+	key, err := encodeKey(k)
+	if err != nil {
+		return v, err
+	}
+	columns, err := UpdateContext(ctx, t.s, t.name, key, ops)
+	if err != nil {
+		return v, err
+	}
+	err = decodeRow(KVP{key, columns}, reflect.ValueOf(&v).Elem())
+	return v, err
+}
+
+// encodeKey encodes the struct or map k into key fields.
+func encodeKey(k interface{}) (map[string]interface{}, error) {
+	value, err := encodeValue(reflect.ValueOf(&k).Elem())
+	if err != nil {
+		return nil, fmt.Errorf("pundun: key: %v", err)
+	}
+	key, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pundun: key type %T is not a struct or map", k)
+	}
+	return key, nil
+}
@@ -1,14 +1,43 @@
 package pundun
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/falkevik/pundun/punduntest"
+	"github.com/golang/protobuf/proto"
+	"github.com/pundunlabs/apollo"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"os"
 	"testing"
 	"time"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+var (
+	testServerOnce sync.Once
+	testServer     *punduntest.Server
+)
+
+// testconnect connects to the node at PUNDUN_TEST_NODE, or to an
+// in-process fake node when it is not set.
 func testconnect() (Session, error) {
-	return Connect("192.168.211.142:8887", "admin", "admin")
+	if host := os.Getenv("PUNDUN_TEST_NODE"); host != "" {
+		return Connect(host, "admin", "admin")
+	}
+	testServerOnce.Do(func() {
+		testServer = punduntest.NewServer()
+	})
+	return ConnectWithConfig(testServer.Addr, "admin", "admin", Config{TLS: testServer.ClientTLS()})
 }
 
 func TestRun0(t *testing.T) {
@@ -426,3 +455,1468 @@ func stringInSlice(a string, list []string) bool {
 	}
 	return false
 }
+
+func TestSendMsgContextCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(client, nil, nil, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done}
+	defer Disconnect(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := SendMsgContext(ctx, s, []byte{1, 2, 3})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancellation took %v", elapsed)
+	}
+}
+
+func TestGetError(t *testing.T) {
+	e := &apollo.Error{Error: &apollo.Error_Misc{Misc: "{error,not_found}"}}
+	err := getError(e)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	var misc *MiscError
+	if !errors.As(err, &misc) || misc.Reason != "{error,not_found}" {
+		t.Fatalf("expected *MiscError, got %#v", err)
+	}
+
+	e = &apollo.Error{Error: &apollo.Error_System{System: "\"no_table\""}}
+	err = getError(e)
+	var sys *SystemError
+	if !errors.As(err, &sys) || !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("expected table not found system error, got %#v", err)
+	}
+
+	e = &apollo.Error{Error: &apollo.Error_Misc{Misc: "{error,invalid}"}}
+	if err = getError(e); errors.Is(err, ErrEndOfTable) {
+		t.Fatalf("invalid is end of table only for iterators, got %v", err)
+	}
+	if err = iteratorError(err); !errors.Is(err, ErrEndOfTable) || !errors.As(err, &misc) {
+		t.Fatalf("expected end of table misc error, got %#v", err)
+	}
+
+	e = &apollo.Error{Error: &apollo.Error_Transport{Transport: "econnreset"}}
+	err = getError(e)
+	var tr *TransportError
+	if !errors.As(err, &tr) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected unclassified transport error, got %#v", err)
+	}
+}
+
+func TestSendMsgSessionClosed(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		// Read the request header, then drop the connection.
+		io.ReadFull(server, make([]byte, 6))
+		server.Close()
+	}()
+
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(client, nil, nil, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done}
+
+	_, err := SendMsgContext(context.Background(), s, []byte{1, 2, 3})
+	if err != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+	_, err = SendMsgContext(context.Background(), s, []byte{1, 2, 3})
+	if err != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed after close, got %v", err)
+	}
+}
+
+func TestSessionReconnect(t *testing.T) {
+	first, firstPeer := net.Pipe()
+	go func() {
+		io.ReadFull(firstPeer, make([]byte, 6))
+		firstPeer.Close()
+	}()
+	dial := func() (net.Conn, error) {
+		conn, peer := net.Pipe()
+		go echoServer(peer)
+		return conn, nil
+	}
+
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	policy := &ReconnectPolicy{InitialBackoff: time.Millisecond}
+	go sessionLoop(first, dial, policy, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done}
+	defer Disconnect(s)
+
+	_, err := SendMsgContext(context.Background(), s, []byte{1})
+	if !IsRetriable(err) {
+		t.Fatalf("expected retriable error, got %v", err)
+	}
+	pdu, err := SendMsgContext(context.Background(), s, []byte{2})
+	if err != nil || !reflect.DeepEqual(pdu, []byte{2}) {
+		t.Fatalf("expected echo after reconnect, got %v, %v", pdu, err)
+	}
+}
+
+// echoServer answers every request on conn with its own payload.
+func echoServer(conn net.Conn) {
+	defer conn.Close()
+	for {
+		buf, err := HRead(conn)
+		if err != nil {
+			return
+		}
+		if _, err := HSend(conn, buf); err != nil {
+			return
+		}
+	}
+}
+
+// echoSession returns a session served by echoServer over a pipe.
+func echoSession() Session {
+	conn, peer := net.Pipe()
+	go echoServer(peer)
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(conn, nil, nil, 0, manChan, sendChan, done)
+	return Session{manChan: manChan, sendChan: sendChan, done: done}
+}
+
+func TestPoolPick(t *testing.T) {
+	a := &poolNode{host: "a", sessions: []Session{echoSession()}, inFlight: []*int32{new(int32)}}
+	b := &poolNode{host: "b", sessions: []Session{echoSession()}, inFlight: []*int32{new(int32)}}
+	p := &Pool{conf: PoolConfig{SessionsPerNode: 1, RetryAfter: time.Minute}, nodes: []*poolNode{a, b}}
+	defer p.Close()
+
+	first, _, _, _ := p.pick(nil)
+	second, _, _, _ := p.pick(nil)
+	if first == second {
+		t.Fatalf("round robin picked %v twice", first.host)
+	}
+
+	p.markDown(a)
+	for i := 0; i < 4; i++ {
+		n, _, _, err := p.pick(nil)
+		if err != nil || n != b {
+			t.Fatalf("expected healthy node b, got %v, %v", n, err)
+		}
+	}
+
+	p.conf.Balance = LeastInFlight
+	p.nodes[0].downUntil = time.Time{}
+	atomic.AddInt32(b.inFlight[0], 1)
+	if n, _, _, _ := p.pick(nil); n != a {
+		t.Fatalf("expected least loaded node a, got %v", n.host)
+	}
+
+	p.markDown(a)
+	p.markDown(b)
+	if _, _, _, err := p.pick(nil); err != ErrNoHealthyNodes {
+		t.Fatalf("expected ErrNoHealthyNodes, got %v", err)
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	failing := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		e := &apollo.Error{Error: &apollo.Error_Transport{Transport: "econnreset"}}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Error{Error: e}}
+	})
+	healthy := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		cols := &apollo.Fields{Fields: fixFields(map[string]interface{}{"name": "b"})}
+		res := &apollo.Response{Result: &apollo.Response_Columns{Columns: cols}}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: res}}
+	})
+	newPool := func() (*Pool, *poolNode) {
+		a := &poolNode{host: "a", sessions: []Session{failing}, inFlight: []*int32{new(int32)}}
+		b := &poolNode{host: "b", sessions: []Session{healthy}, inFlight: []*int32{new(int32)}}
+		return &Pool{conf: PoolConfig{SessionsPerNode: 1, RetryAfter: time.Minute}, nodes: []*poolNode{a, b}}, a
+	}
+	key := map[string]interface{}{"id": "1"}
+
+	p, a := newPool()
+	res, err := p.Read("t", key)
+	if err != nil || res["name"] != "b" {
+		t.Fatalf("expected read to fail over to node b, got %v, %v", res, err)
+	}
+	if !time.Now().Before(a.downUntil) {
+		t.Fatal("failing node was not marked down")
+	}
+
+	p, _ = newPool()
+	var tr *TransportError
+	if _, err := p.Write("t", key, key); !errors.As(err, &tr) {
+		t.Fatalf("expected write to fail without retry, got %v", err)
+	}
+
+	if isTransportFailure(ErrTimeout) {
+		t.Fatal("a request timeout must not mark the node down")
+	}
+}
+
+func TestPoolIterators(t *testing.T) {
+	var nodes []*poolNode
+	for _, host := range []string{"a", "b"} {
+		e := punduntest.NewEngine()
+		s := ConnectConn(punduntest.Pipe(e), Config{RequestTimeout: 5 * time.Second})
+		defer Disconnect(s)
+		if _, err := CreateTable(s, "t", []string{"id"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		for id := int64(1); id <= 3; id++ {
+			if _, err := Write(s, "t", map[string]interface{}{"id": id}, map[string]interface{}{"node": host}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, &poolNode{host: host, sessions: []Session{s}, inFlight: []*int32{new(int32)}})
+	}
+	p := &Pool{conf: PoolConfig{SessionsPerNode: 1, RetryAfter: time.Minute}, nodes: nodes}
+
+	// Round robin would send each step to the other node.
+	it, err := p.First("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := it.Kvp.Columns["node"]
+	count := 1
+	for {
+		kvp, err := p.Next(it.It)
+		if errors.Is(err, ErrEndOfTable) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if kvp.Columns["node"] != node {
+			t.Fatalf("iterator moved from node %v to %v", node, kvp.Columns["node"])
+		}
+		count++
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 keys, got %v", count)
+	}
+	if _, err := p.Next(it.It); !errors.Is(err, ErrUnknownIterator) {
+		t.Fatalf("expected the iterator to be released, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(client, nil, nil, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done, requestTimeout: time.Hour}
+	defer Disconnect(s)
+
+	ctx := WithRequestTimeout(context.Background(), 20*time.Millisecond)
+	_, err := SendMsgContext(ctx, s, []byte{1})
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestRequestTimeoutReconnecting(t *testing.T) {
+	first, firstPeer := net.Pipe()
+	firstPeer.Close()
+	dial := func() (net.Conn, error) {
+		return nil, errors.New("node down")
+	}
+
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	policy := &ReconnectPolicy{InitialBackoff: time.Hour}
+	go sessionLoop(first, dial, policy, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done, requestTimeout: 20 * time.Millisecond}
+	defer Disconnect(s)
+
+	// Wait for the lost connection to be noticed.
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	_, err := SendMsgContext(context.Background(), s, []byte{1})
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	go echoServer(peer)
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(conn, nil, nil, 10*time.Millisecond, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, done: done}
+
+	if _, err := SendMsgContext(context.Background(), s, []byte{1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle session was not closed")
+	}
+	if _, err := SendMsgContext(context.Background(), s, []byte{1}); err != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+}
+
+type codecAddress struct {
+	Street string `pundun:"street"`
+	Zip    int    `pundun:"zip"`
+}
+
+type codecRow struct {
+	Imsi    string            `pundun:"imsi,key"`
+	Ts      int64             `pundun:"ts,key"`
+	Name    string            `pundun:"name"`
+	Counter uint32            `pundun:"counter"`
+	Ratio   float64           `pundun:"ratio"`
+	Active  bool              `pundun:"active"`
+	Bin     []byte            `pundun:"bin"`
+	Tags    []string          `pundun:"tags"`
+	Address codecAddress      `pundun:"address"`
+	Attrs   map[string]int    `pundun:"attrs"`
+	Nick    *string           `pundun:"nick"`
+	Note    string            `pundun:"note,omitempty"`
+	Skipped string            `pundun:"-"`
+	Extra   map[string]string `pundun:"extra"`
+}
+
+func TestRowCodec(t *testing.T) {
+	in := codecRow{
+		Imsi:    "123456789012345",
+		Ts:      1500000000000,
+		Name:    "John",
+		Counter: 7,
+		Ratio:   0.5,
+		Active:  true,
+		Bin:     []byte{0, 0, 0, 1},
+		Tags:    []string{"a", "b"},
+		Address: codecAddress{"Main St", 12345},
+		Attrs:   map[string]int{"x": 1},
+		Skipped: "ignored",
+	}
+	key, columns, err := MarshalRow(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := columns["note"]; ok {
+		t.Fatal("omitempty column was encoded")
+	}
+	if _, ok := columns["Skipped"]; ok {
+		t.Fatal("skipped field was encoded")
+	}
+	if len(key) != 2 {
+		t.Fatalf("unexpected key %v", key)
+	}
+
+	// Round trip through the apollo representation.
+	kvp := KVP{formatFields(fixFields(key)), formatFields(fixFields(columns))}
+	var out codecRow
+	if err := UnmarshalRow(kvp, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+
+	var rows []*codecRow
+	if err := UnmarshalRows([]KVP{kvp, kvp}, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1].Name != "John" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+
+	var loose struct {
+		A interface{} `pundun:"a"`
+	}
+	loose.A = "stale"
+	if err := UnmarshalRow(KVP{Columns: map[string]interface{}{"a": nil}}, &loose); err != nil || loose.A != nil {
+		t.Fatalf("expected a nil column, got %v, %v", loose.A, err)
+	}
+}
+
+type codecBase struct {
+	ID   int64  `pundun:"id,key"`
+	Name string `pundun:"name"`
+}
+
+type codecEmbedding struct {
+	codecBase
+	codecAddress
+	Name string `pundun:"name"`
+}
+
+func TestRowCodecEmbedded(t *testing.T) {
+	in := codecEmbedding{codecBase{7, "base"}, codecAddress{"Main St", 12345}, "outer"}
+	key, columns, err := MarshalRow(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key["id"] != int64(7) || columns["name"] != "outer" || columns["street"] != "Main St" {
+		t.Fatalf("embedded fields not flattened: %v %v", key, columns)
+	}
+	var out codecEmbedding
+	kvp := KVP{formatFields(fixFields(key)), formatFields(fixFields(columns))}
+	if err := UnmarshalRow(kvp, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.codecBase.Name = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+
+	type stamped struct {
+		At time.Time `pundun:"at"`
+	}
+	if _, _, err := MarshalRow(stamped{time.Now()}); err == nil {
+		t.Fatal("expected an error for time.Time")
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		cols := &apollo.Fields{Fields: fixFields(map[string]interface{}{"name": "John"})}
+		res := &apollo.Response{Result: &apollo.Response_Columns{Columns: cols}}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: res}}
+	})
+	defer Disconnect(s)
+
+	var row codecBase
+	if err := ReadInto(s, "t", map[string]interface{}{"id": 7}, &row); err != nil {
+		t.Fatal(err)
+	}
+	if row != (codecBase{7, "John"}) {
+		t.Fatalf("unexpected row %+v", row)
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	type key struct {
+		Imsi string `pundun:"imsi"`
+		Ts   int64  `pundun:"ts"`
+	}
+	k, err := encodeKey(key{"1", 2})
+	if err != nil || !reflect.DeepEqual(k, map[string]interface{}{"imsi": "1", "ts": int64(2)}) {
+		t.Fatalf("unexpected key %v, %v", k, err)
+	}
+	if _, err := encodeKey("1"); err == nil {
+		t.Fatal("expected error for scalar key")
+	}
+}
+
+func TestTable(t *testing.T) {
+	type key struct {
+		ID string `pundun:"id"`
+		Ts int64  `pundun:"ts"`
+	}
+	type event struct {
+		ID    string `pundun:"id,key"`
+		Ts    int64  `pundun:"ts,key"`
+		Name  string `pundun:"name"`
+		Count int64  `pundun:"count"`
+	}
+	s := ConnectConn(punduntest.Pipe(punduntest.NewEngine()), Config{RequestTimeout: 5 * time.Second})
+	defer Disconnect(s)
+	if _, err := CreateTable(s, "events", []string{"id", "ts"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	events := NewTable[key, event](s, "events")
+
+	for ts := int64(1); ts <= 3; ts++ {
+		if err := events.Put(key{"a", ts}, event{Name: fmt.Sprint("e", ts), Count: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := events.Get(key{"a", 2})
+	if err != nil || got != (event{"a", 2, "e2", 2}) {
+		t.Fatalf("unexpected get %+v, %v", got, err)
+	}
+	if _, err := events.Get(key{"b", 1}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	rows, err := events.Range(key{"a", 3}, key{"a", 1}, 10)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("unexpected range %v, %v", rows, err)
+	}
+	if rows[0].Key != (key{"a", 3}) || rows[2].Value != (event{"a", 1, "e1", 1}) {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+
+	up, err := events.Update(key{"a", 1}, UpdateOperation{Field: "count", Instruction: Increment, Value: 5})
+	if err != nil || up.Count != 6 || up.ID != "a" || up.Ts != 1 {
+		t.Fatalf("unexpected update %+v, %v", up, err)
+	}
+
+	if err := events.Delete(key{"a", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events.Get(key{"a", 1}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestParseTableOptions(t *testing.T) {
+	opts, err := ParseTableOptions(map[string]interface{}{
+		"type":               "rocksdb",
+		"data_model":         "array",
+		"comparator":         "descending",
+		"time_series":        false,
+		"num_of_shards":      8,
+		"distributed":        false,
+		"replication_factor": 1,
+		"hash_exclude":       []string{"ts"},
+		"ttl":                float64(60),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts.Type != Rocksdb || opts.NumOfShards != 8 || opts.TTL != 60 || *opts.Distributed {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	fixed, err := fixTableOptions(opts)
+	if err != nil || len(fixed) != 9 {
+		t.Fatalf("expected 9 apollo options, got %v, %v", len(fixed), err)
+	}
+
+	invalid := []map[string]interface{}{
+		{"no_such_option": 1},
+		{"type": "nosql"},
+		{"num_of_shards": "8"},
+		{"num_of_shards": -1},
+		{"distributed": "yes"},
+		{"hash_exclude": []interface{}{1}},
+	}
+	for _, o := range invalid {
+		if _, err := ParseTableOptions(o); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption for %v, got %v", o, err)
+		}
+	}
+}
+
+func TestParseTableInfo(t *testing.T) {
+	m := map[string]interface{}{
+		"name":          "ct",
+		"key":           []interface{}{"imsi", "ts"},
+		"type":          "rocksdb",
+		"comparator":    "descending",
+		"distributed":   true,
+		"num_of_shards": int64(8),
+		"shards":        []interface{}{"ct_shard0", map[string]interface{}{"name": "ct_shard1", "node": "pundun@host"}},
+		"index_on":      []interface{}{"name"},
+		"wrapper":       "undefined",
+		"ttl":           "infinity",
+	}
+	info := ParseTableInfo(m)
+	if info.Name != "ct" || !reflect.DeepEqual(info.Key, []string{"imsi", "ts"}) ||
+		!info.Distributed || info.NumOfShards != 8 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if len(info.Shards) != 2 || info.Shards[1].Node != "pundun@host" || info.Indexes[0].Column != "name" {
+		t.Fatalf("unexpected shards or indexes %+v", info)
+	}
+	if info.Raw["wrapper"] != "undefined" || info.Raw["ttl"] != "infinity" {
+		t.Fatalf("unexpected raw attributes %v", info.Raw)
+	}
+	if opts := info.Options(); opts.Distributed == nil || !*opts.Distributed || opts.TimeSeries != nil {
+		t.Fatalf("expected only reported flags in options %+v", opts)
+	}
+	m["distributed"] = false
+	if opts := ParseTableInfo(m).Options(); opts.Distributed == nil || *opts.Distributed {
+		t.Fatalf("expected distributed false in options %+v", opts)
+	}
+
+	other := info
+	other.NumOfShards = 4
+	other.Raw = map[string]interface{}{"wrapper": "undefined"}
+	diffs := DiffTableInfo(info, other)
+	if len(diffs) != 2 || diffs[0].Attribute != "num_of_shards" || diffs[1].Attribute != "ttl" {
+		t.Fatalf("unexpected diffs %+v", diffs)
+	}
+}
+
+func TestCursorExhaustion(t *testing.T) {
+	c := newCursor(context.Background(), Session{}, Iterator{}, iteratorError(&MiscError{"invalid"}))
+	if c.Next() || c.Err() != nil {
+		t.Fatalf("expected clean exhaustion, got %v", c.Err())
+	}
+
+	done := make(chan struct{})
+	close(done)
+	s := Session{tidChan: make(chan uint16, 1), done: done}
+	s.tidChan <- 0
+	kvp := KVP{Key: map[string]interface{}{"id": "1"}}
+	c = newCursor(context.Background(), s, Iterator{kvp, []byte{1}}, nil)
+	if !c.Next() || !reflect.DeepEqual(c.KVP(), kvp) {
+		t.Fatalf("expected the starting position, got %v", c.KVP())
+	}
+	if c.Next() || c.Err() != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed, got %v", c.Err())
+	}
+	c.Close()
+	if c.Prev() {
+		t.Fatal("closed cursor moved")
+	}
+}
+
+func TestBatchPipelined(t *testing.T) {
+	conn, peer := net.Pipe()
+	go func() {
+		defer peer.Close()
+		var reqs [][]byte
+		for len(reqs) < 3 {
+			buf, err := HRead(peer)
+			if err != nil {
+				return
+			}
+			reqs = append(reqs, buf)
+		}
+		for i := len(reqs) - 1; i >= 0; i-- {
+			pdu := &apollo.ApolloPdu{}
+			if err := proto.Unmarshal(reqs[i][2:], pdu); err != nil {
+				return
+			}
+			res := &apollo.ApolloPdu{TransactionId: pdu.TransactionId}
+			if pdu.GetDelete() != nil {
+				res.Procedure = &apollo.ApolloPdu_Error{
+					Error: &apollo.Error{Error: &apollo.Error_Misc{Misc: "{error,not_found}"}},
+				}
+			} else {
+				res.Procedure = &apollo.ApolloPdu_Response{
+					Response: &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}},
+				}
+			}
+			bin, _ := proto.Marshal(res)
+			if _, err := HSend(peer, append(reqs[i][:2:2], bin...)); err != nil {
+				return
+			}
+		}
+	}()
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(conn, nil, nil, 0, manChan, sendChan, done)
+	s := Session{manChan: manChan, sendChan: sendChan, tidChan: make(chan uint16, 8), done: done}
+	for i := uint16(0); i < 8; i++ {
+		s.tidChan <- i
+	}
+	defer conn.Close()
+
+	key := map[string]interface{}{"id": "1"}
+	b := NewBatch().
+		Write("t", key, map[string]interface{}{"v": int64(1)}).
+		Delete("t", key).
+		Write("t", key, map[string]interface{}{"v": int64(2)})
+	results := b.Run(s)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].Result != OK {
+		t.Fatalf("unexpected first result %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %+v", results[1])
+	}
+	if results[2].Err != nil || results[2].Result != OK {
+		t.Fatalf("unexpected last result %+v", results[2])
+	}
+}
+
+// pduSession returns a session whose requests are answered in order
+// by handle over a pipe.
+func pduSession(handle func(*apollo.ApolloPdu) *apollo.ApolloPdu) Session {
+	conn, peer := net.Pipe()
+	go func() {
+		defer peer.Close()
+		for {
+			buf, err := HRead(peer)
+			if err != nil {
+				return
+			}
+			pdu := &apollo.ApolloPdu{}
+			if err := proto.Unmarshal(buf[2:], pdu); err != nil {
+				return
+			}
+			res := handle(pdu)
+			res.TransactionId = pdu.TransactionId
+			bin, _ := proto.Marshal(res)
+			if _, err := HSend(peer, append(buf[:2:2], bin...)); err != nil {
+				return
+			}
+		}
+	}()
+	manChan := make(chan int, 1)
+	sendChan := make(chan caller, 16)
+	done := make(chan struct{})
+	go sessionLoop(conn, nil, nil, 0, manChan, sendChan, done)
+	tidChan := make(chan uint16, 1)
+	go tidServer(0, tidChan)
+	return Session{manChan: manChan, sendChan: sendChan, tidChan: tidChan, done: done}
+}
+
+func TestLoad(t *testing.T) {
+	var mu sync.Mutex
+	written := make(map[string]map[string]interface{})
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		w := pdu.GetWrite()
+		key := formatFields(w.Key)
+		mu.Lock()
+		written[key["id"].(string)] = formatFields(w.Columns)
+		mu.Unlock()
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+			Response: &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}},
+		}}
+	})
+	mapping := LoadMapping{
+		Key:       []string{"id"},
+		Names:     map[string]string{"n": "count"},
+		Types:     map[string]int{"n": IntType, "ok": BooleanType},
+		BatchSize: 2,
+	}
+	in := "id,n,ok\na,1,true\nb,x,false\n,3,true\nc,4,\nd,5,false\n"
+	var log strings.Builder
+	var progress []LoadStats
+	mapping.ErrorLog = &log
+	mapping.Progress = func(st LoadStats) { progress = append(progress, st) }
+	stats, err := Load(context.Background(), s, "t", strings.NewReader(in), CSV, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (LoadStats{Offset: 5, Written: 3, Failed: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(progress) != 2 || progress[0].Offset != 4 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if !strings.HasPrefix(log.String(), "row 1: field \"n\"") || !strings.Contains(log.String(), "row 2: missing key field") {
+		t.Fatalf("unexpected error log %q", log.String())
+	}
+	want := map[string]interface{}{"count": int64(4)}
+	if !reflect.DeepEqual(written["c"], want) {
+		t.Fatalf("expected %v, got %v", want, written["c"])
+	}
+
+	written = make(map[string]map[string]interface{})
+	mapping = LoadMapping{Key: []string{"id"}, Offset: 1}
+	in = "{\"id\":\"a\",\"v\":1}\n{\"id\":\"b\",\"v\":1.5,\"l\":[2]}\n"
+	stats, err = Load(context.Background(), s, "t", strings.NewReader(in), NDJSON, mapping)
+	if err != nil || stats.Written != 1 {
+		t.Fatalf("unexpected result %+v, %v", stats, err)
+	}
+	want = map[string]interface{}{"v": 1.5, "l": []interface{}{int64(2)}}
+	if _, ok := written["a"]; ok || !reflect.DeepEqual(written["b"], want) {
+		t.Fatalf("expected only b = %v, got %v", want, written)
+	}
+	// A malformed line is logged and skipped.
+	written = make(map[string]map[string]interface{})
+	log.Reset()
+	mapping = LoadMapping{Key: []string{"id"}, ErrorLog: &log}
+	in = "{\"id\":\"a\"}\n{\"id\":\"b\",\n\n{\"id\":\"c\"}\n"
+	stats, err = Load(context.Background(), s, "t", strings.NewReader(in), NDJSON, mapping)
+	if err != nil || stats != (LoadStats{Offset: 3, Written: 2, Failed: 1}) {
+		t.Fatalf("unexpected result %+v, %v", stats, err)
+	}
+	if !strings.HasPrefix(log.String(), "row 1: ") || len(written) != 2 {
+		t.Fatalf("unexpected error log %q, written %v", log.String(), written)
+	}
+
+	// A JSON array must be complete.
+	mapping = LoadMapping{Key: []string{"id"}}
+	in = `[{"id":"a"},{"id":"b"}]`
+	if stats, err = Load(context.Background(), s, "t", strings.NewReader(in), JSON, mapping); err != nil || stats.Written != 2 {
+		t.Fatalf("unexpected result %+v, %v", stats, err)
+	}
+	in = `[{"id":"a"},{"id":"b"}`
+	if _, err = Load(context.Background(), s, "t", strings.NewReader(in), JSON, mapping); err == nil {
+		t.Fatal("expected a truncated input error")
+	}
+}
+
+func TestExportDump(t *testing.T) {
+	row := func(id int64) *apollo.KeyColumnsPair {
+		return &apollo.KeyColumnsPair{
+			Key: fixFields(map[string]interface{}{"id": id}),
+			Columns: []*apollo.Field{
+				{Name: "null", Value: &apollo.Value{Type: &apollo.Value_Null{}}},
+				{Name: "bin", Value: fixValue([]byte{0, 1})},
+				{Name: "map", Value: fixValue(map[string]interface{}{"l": []interface{}{1.5, "x"}})},
+			},
+		}
+	}
+	rows := []*apollo.KeyColumnsPair{row(1), row(2), row(3)}
+	kcl := func(list []*apollo.KeyColumnsPair, cont *apollo.KeyColumnsPair) *apollo.ApolloPdu {
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+			Response: &apollo.Response{Result: &apollo.Response_KeyColumnsList{
+				KeyColumnsList: &apollo.KeyColumnsList{List: list, Continuation: cont},
+			}},
+		}}
+	}
+	var mu sync.Mutex
+	var loaded []*apollo.KeyColumnsPair
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		switch p := pdu.GetProcedure().(type) {
+		case *apollo.ApolloPdu_First:
+			return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+				Response: &apollo.Response{Result: &apollo.Response_KcpIt{
+					KcpIt: &apollo.KcpIt{KeyColumnsPair: rows[0], It: []byte{1}},
+				}},
+			}}
+		case *apollo.ApolloPdu_ReadRangeN:
+			// Pages overlap on the continuation key.
+			if formatFields(p.ReadRangeN.StartKey)["id"] == int64(1) {
+				return kcl(rows[:2], &apollo.KeyColumnsPair{Key: rows[1].Key})
+			}
+			return kcl(rows[1:], nil)
+		case *apollo.ApolloPdu_Write:
+			mu.Lock()
+			loaded = append(loaded, &apollo.KeyColumnsPair{Key: p.Write.Key, Columns: p.Write.Columns})
+			mu.Unlock()
+		}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+			Response: &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}},
+		}}
+	})
+
+	var dump bytes.Buffer
+	n, err := Export(context.Background(), s, "t", &dump, Dump, ExportOptions{})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 rows, got %d, %v", n, err)
+	}
+	stats, err := Load(context.Background(), s, "t2", bytes.NewReader(dump.Bytes()), Dump, LoadMapping{})
+	if err != nil || stats.Written != 3 {
+		t.Fatalf("unexpected load %+v, %v", stats, err)
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		return formatFields(loaded[i].Key)["id"].(int64) < formatFields(loaded[j].Key)["id"].(int64)
+	})
+	if !reflect.DeepEqual(loaded, rows) {
+		t.Fatalf("dump did not round-trip: %v", loaded)
+	}
+
+	var out bytes.Buffer
+	if _, err := Export(context.Background(), s, "t", &out, NDJSON, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	first := strings.SplitN(out.String(), "\n", 2)[0]
+	if first != `{"bin":"AAE=","id":1,"map":{"l":[1.5,"x"]},"null":null}` {
+		t.Fatalf("unexpected NDJSON row %s", first)
+	}
+	out.Reset()
+	if _, err := Export(context.Background(), s, "t", &out, CSV, ExportOptions{Fields: []string{"id", "bin"}}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "id,bin\n1,AAE=\n2,AAE=\n3,AAE=\n" {
+		t.Fatalf("unexpected CSV %q", out.String())
+	}
+}
+
+func TestReadRawRangeWithoutContinuation(t *testing.T) {
+	const total = 2*exportBatch + 500
+	key := func(id int) []*apollo.Field {
+		return fixFields(map[string]interface{}{"id": id})
+	}
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		var res *apollo.Response
+		switch p := pdu.GetProcedure().(type) {
+		case *apollo.ApolloPdu_First:
+			kcp := &apollo.KeyColumnsPair{Key: key(0)}
+			res = &apollo.Response{Result: &apollo.Response_KcpIt{KcpIt: &apollo.KcpIt{KeyColumnsPair: kcp}}}
+		case *apollo.ApolloPdu_ReadRangeN:
+			// Pages start at the start key and carry no continuation.
+			var list []*apollo.KeyColumnsPair
+			from := int(formatFields(p.ReadRangeN.StartKey)["id"].(int64))
+			for id := from; id < total && id < from+int(p.ReadRangeN.N); id++ {
+				list = append(list, &apollo.KeyColumnsPair{Key: key(id)})
+			}
+			res = &apollo.Response{Result: &apollo.Response_KeyColumnsList{
+				KeyColumnsList: &apollo.KeyColumnsList{List: list},
+			}}
+		}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: res}}
+	})
+	defer Disconnect(s)
+
+	next := int64(0)
+	err := readRawRange(context.Background(), s, "t", nil, nil, func(kcp *apollo.KeyColumnsPair) error {
+		if id := formatFields(kcp.Key)["id"]; id != next {
+			return fmt.Errorf("expected id %d, got %v", next, id)
+		}
+		next++
+		return nil
+	})
+	if err != nil || next != total {
+		t.Fatalf("read %d of %d rows, %v", next, total, err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ok := &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+		Response: &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}},
+	}}
+	response := func(r *apollo.Response) *apollo.ApolloPdu {
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: r}}
+	}
+	rows := []*apollo.KeyColumnsPair{
+		{Key: fixFields(map[string]interface{}{"id": "a"}), Columns: fixFields(map[string]interface{}{"v": int64(1)})},
+		{Key: fixFields(map[string]interface{}{"id": "b"}), Columns: fixFields(map[string]interface{}{"v": int64(2)})},
+	}
+	info := map[string]interface{}{
+		"name":          "t",
+		"key":           []interface{}{"id"},
+		"type":          "rocksdb",
+		"comparator":    "descending",
+		"num_of_shards": int64(4),
+		"distributed":   true,
+		"index_on": []interface{}{map[string]interface{}{
+			"column":  "text",
+			"options": map[string]interface{}{"char_filter": "nfkc", "token_filter": map[string]interface{}{"stats": "unique"}},
+		}},
+	}
+	var mu sync.Mutex
+	var created *apollo.CreateTable
+	var indexed *apollo.AddIndex
+	var written []string
+	s := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		mu.Lock()
+		defer mu.Unlock()
+		switch p := pdu.GetProcedure().(type) {
+		case *apollo.ApolloPdu_ListTables:
+			return response(&apollo.Response{Result: &apollo.Response_StringList{
+				StringList: &apollo.FieldNames{FieldNames: []string{"t"}},
+			}})
+		case *apollo.ApolloPdu_TableInfo:
+			return response(&apollo.Response{Result: &apollo.Response_Proplist{
+				Proplist: &apollo.Fields{Fields: fixFields(info)},
+			}})
+		case *apollo.ApolloPdu_First:
+			return response(&apollo.Response{Result: &apollo.Response_KcpIt{
+				KcpIt: &apollo.KcpIt{KeyColumnsPair: rows[0], It: []byte{1}},
+			}})
+		case *apollo.ApolloPdu_ReadRangeN:
+			return response(&apollo.Response{Result: &apollo.Response_KeyColumnsList{
+				KeyColumnsList: &apollo.KeyColumnsList{List: rows},
+			}})
+		case *apollo.ApolloPdu_CreateTable:
+			created = p.CreateTable
+		case *apollo.ApolloPdu_AddIndex:
+			indexed = p.AddIndex
+		case *apollo.ApolloPdu_Write:
+			written = append(written, p.Write.TableName)
+		}
+		return ok
+	})
+
+	var archive bytes.Buffer
+	if err := Backup(context.Background(), s, &archive, nil); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(context.Background(), s, &archive, RestoreOptions{Rename: map[string]string{"t": "t2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored["t2"].Written != 2 || !reflect.DeepEqual(written, []string{"t2", "t2"}) {
+		t.Fatalf("unexpected restore %v, writes %v", restored, written)
+	}
+	if created == nil || created.TableName != "t2" || !reflect.DeepEqual(created.Keys, []string{"id"}) {
+		t.Fatalf("unexpected create table %+v", created)
+	}
+	opts := created.TableOptions
+	if len(opts) == 0 {
+		t.Fatal("table options were not restored")
+	}
+	if indexed == nil || len(indexed.Config) != 1 || indexed.Config[0].Column != "text" ||
+		indexed.Config[0].Options.CharFilter != apollo.CharFilter_NFKC ||
+		indexed.Config[0].Options.TokenFilter.Stats != apollo.TokenStats_UNIQUE {
+		t.Fatalf("unexpected add index %+v", indexed)
+	}
+}
+
+func TestCopyTable(t *testing.T) {
+	ok := &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{
+		Response: &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}},
+	}}
+	response := func(r *apollo.Response) *apollo.ApolloPdu {
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: r}}
+	}
+	// serve answers reads from rows and stores writes in them.
+	serve := func(mu *sync.Mutex, rows *[]*apollo.KeyColumnsPair, created **apollo.CreateTable) func(*apollo.ApolloPdu) *apollo.ApolloPdu {
+		find := func(key []*apollo.Field) int {
+			id := formatFields(key)["id"]
+			for i, r := range *rows {
+				if r.Key[0].Value.GetInt() == id {
+					return i
+				}
+			}
+			return -1
+		}
+		return func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+			mu.Lock()
+			defer mu.Unlock()
+			switch p := pdu.GetProcedure().(type) {
+			case *apollo.ApolloPdu_TableInfo:
+				return response(&apollo.Response{Result: &apollo.Response_Proplist{
+					Proplist: &apollo.Fields{Fields: fixFields(map[string]interface{}{
+						"key": []interface{}{"id"}, "num_of_shards": int64(2), "hashing_method": "uniform",
+					})},
+				}})
+			case *apollo.ApolloPdu_First:
+				return response(&apollo.Response{Result: &apollo.Response_KcpIt{
+					KcpIt: &apollo.KcpIt{KeyColumnsPair: (*rows)[0], It: []byte{1}},
+				}})
+			case *apollo.ApolloPdu_ReadRangeN:
+				// Pages of at most N rows from the start key, without
+				// a continuation.
+				list := *rows
+				if i := find(p.ReadRangeN.StartKey); i >= 0 {
+					list = list[i:]
+				}
+				if n := int(p.ReadRangeN.N); len(list) > n {
+					list = list[:n]
+				}
+				return response(&apollo.Response{Result: &apollo.Response_KeyColumnsList{
+					KeyColumnsList: &apollo.KeyColumnsList{List: list},
+				}})
+			case *apollo.ApolloPdu_CreateTable:
+				*created = p.CreateTable
+			case *apollo.ApolloPdu_Write:
+				kcp := &apollo.KeyColumnsPair{Key: p.Write.Key, Columns: p.Write.Columns}
+				if i := find(p.Write.Key); i >= 0 {
+					(*rows)[i] = kcp
+				} else {
+					*rows = append(*rows, kcp)
+				}
+			}
+			return ok
+		}
+	}
+	var srcMu, dstMu sync.Mutex
+	var srcRows, dstRows []*apollo.KeyColumnsPair
+	var srcCreated, dstCreated *apollo.CreateTable
+	for i := 0; i < 1200; i++ {
+		srcRows = append(srcRows, &apollo.KeyColumnsPair{
+			Key:     fixFields(map[string]interface{}{"id": int64(i)}),
+			Columns: fixFields(map[string]interface{}{"v": fmt.Sprint(i), "l": []interface{}{true, 1.5}}),
+		})
+	}
+	src := pduSession(serve(&srcMu, &srcRows, &srcCreated))
+	dst := pduSession(serve(&dstMu, &dstRows, &dstCreated))
+
+	opts := CopyOptions{Options: map[string]interface{}{"num_of_shards": 8}, Concurrency: 3, Verify: true}
+	res, err := CopyTable(src, dst, "t", "t2", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 1200 || res.DstRows != 1200 || res.SrcChecksum != res.DstChecksum {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if dstCreated == nil || dstCreated.TableName != "t2" || !reflect.DeepEqual(dstCreated.Keys, []string{"id"}) {
+		t.Fatalf("unexpected create table %+v", dstCreated)
+	}
+
+	// A row only in the destination fails verification.
+	dstMu.Lock()
+	dstRows = append(dstRows, &apollo.KeyColumnsPair{Key: fixFields(map[string]interface{}{"id": int64(-1)})})
+	dstMu.Unlock()
+	if _, err := CopyTable(src, dst, "t", "t2", CopyOptions{Existing: true, Verify: true}); !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("expected ErrVerifyFailed, got %v", err)
+	}
+}
+
+func TestDiffTables(t *testing.T) {
+	serve := func(list []*apollo.KeyColumnsPair) Session {
+		return pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+			r := &apollo.Response{}
+			switch pdu.GetProcedure().(type) {
+			case *apollo.ApolloPdu_TableInfo:
+				r.Result = &apollo.Response_Proplist{Proplist: &apollo.Fields{Fields: fixFields(map[string]interface{}{
+					"key": []interface{}{"id"}, "comparator": "ascending",
+				})}}
+			case *apollo.ApolloPdu_First:
+				r.Result = &apollo.Response_KcpIt{KcpIt: &apollo.KcpIt{KeyColumnsPair: list[0], It: []byte{1}}}
+			case *apollo.ApolloPdu_ReadRangeN:
+				r.Result = &apollo.Response_KeyColumnsList{KeyColumnsList: &apollo.KeyColumnsList{List: list}}
+			}
+			return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: r}}
+		})
+	}
+	table := func(rows map[int64]string) Session {
+		var list []*apollo.KeyColumnsPair
+		var ids []int64
+		for id := range rows {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			list = append(list, &apollo.KeyColumnsPair{
+				Key:     fixFields(map[string]interface{}{"id": id}),
+				Columns: fixFields(map[string]interface{}{"v": rows[id], "n": []interface{}{int64(1)},
+					"d": 1.0, "b": []byte{1}}),
+			})
+		}
+		return serve(list)
+	}
+	a := table(map[int64]string{1: "a", 2: "b", 3: "c", 10: "j"})
+	b := table(map[int64]string{2: "x", 3: "c", 4: "d", 10: "j"})
+
+	var diffs []RowDiff
+	var repair bytes.Buffer
+	opts := DiffOptions{
+		Report: func(d RowDiff) error { diffs = append(diffs, d); return nil },
+		Repair: &repair,
+	}
+	sum, err := DiffTables(context.Background(), a, "a", b, "b", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (DiffSummary{Rows: 5, Missing: 1, Extra: 1, Changed: 1}) {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if len(diffs) != 3 || diffs[0].Kind != MissingRow || diffs[1].Kind != ChangedRow || diffs[2].Kind != ExtraRow {
+		t.Fatalf("unexpected diffs %+v", diffs)
+	}
+	if !reflect.DeepEqual(diffs[1].Columns, []ColumnDiff{{"v", "b", "x"}}) {
+		t.Fatalf("unexpected column diffs %+v", diffs[1].Columns)
+	}
+	lines := strings.Split(strings.TrimSpace(repair.String()), "\n")
+	if len(lines) != 3 || lines[2] != `{"op":"delete","table":"b","key":{"id":{"int":4}}}` ||
+		lines[0] != `{"op":"write","table":"b","key":{"id":{"int":1}},"columns":{"b":{"binary":"AQ=="},`+
+			`"d":{"double":1},"n":{"list":[{"int":1}]},"v":{"string":"a"}}}` {
+		t.Fatalf("unexpected repair script %q", repair.String())
+	}
+
+	// A table failing mid-read is not taken as exhausted.
+	failing := pduSession(func(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+		r := &apollo.Response{}
+		switch p := pdu.GetProcedure().(type) {
+		case *apollo.ApolloPdu_TableInfo:
+			r.Result = &apollo.Response_Proplist{Proplist: &apollo.Fields{Fields: fixFields(map[string]interface{}{
+				"key": []interface{}{"id"}, "comparator": "ascending",
+			})}}
+		case *apollo.ApolloPdu_First:
+			r.Result = &apollo.Response_KcpIt{KcpIt: &apollo.KcpIt{KeyColumnsPair: &apollo.KeyColumnsPair{
+				Key: fixFields(map[string]interface{}{"id": int64(1)}),
+			}, It: []byte{1}}}
+		case *apollo.ApolloPdu_ReadRangeN:
+			if formatFields(p.ReadRangeN.GetStartKey())["id"] != int64(1) {
+				return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Error{
+					Error: &apollo.Error{Error: &apollo.Error_System{System: "{error,disk_failure}"}},
+				}}
+			}
+			var list []*apollo.KeyColumnsPair
+			for _, id := range []int64{1, 2} {
+				list = append(list, &apollo.KeyColumnsPair{
+					Key:     fixFields(map[string]interface{}{"id": id}),
+					Columns: fixFields(map[string]interface{}{"v": "a"}),
+				})
+			}
+			r.Result = &apollo.Response_KeyColumnsList{KeyColumnsList: &apollo.KeyColumnsList{
+				List:         list,
+				Continuation: &apollo.KeyColumnsPair{Key: fixFields(map[string]interface{}{"id": int64(3)})},
+			}}
+		}
+		return &apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Response{Response: r}}
+	})
+	b = table(map[int64]string{5: "e", 6: "f"})
+	repair.Reset()
+	if _, err := DiffTables(context.Background(), failing, "a", b, "b", DiffOptions{Repair: &repair}); err == nil {
+		t.Fatal("expected the read error")
+	}
+	if strings.Contains(repair.String(), "delete") {
+		t.Fatalf("unexpected repair script %q", repair.String())
+	}
+
+	// Null keys come first, as the node returns them.
+	row := func(id interface{}) *apollo.KeyColumnsPair {
+		return &apollo.KeyColumnsPair{Key: fixFields(map[string]interface{}{"id": id})}
+	}
+	a = serve([]*apollo.KeyColumnsPair{row(nil), row(int64(5)), row([]interface{}{int64(1), "b"})})
+	b = serve([]*apollo.KeyColumnsPair{row(int64(5)), row([]interface{}{1.0, "b"})})
+	diffs = nil
+	sum, err = DiffTables(context.Background(), a, "a", b, "b", DiffOptions{
+		Report: func(d RowDiff) error { diffs = append(diffs, d); return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (DiffSummary{Rows: 3, Missing: 1}) || !isNull(diffs[0].Key["id"]) {
+		t.Fatalf("unexpected summary %+v, %+v", sum, diffs)
+	}
+	if c := compareKeys([]string{"id"}, row(nil).Key, row(int64(5)).Key); c != -1 {
+		t.Fatalf("expected null before numbers, got %d", c)
+	}
+}
+
+func TestFakeServer(t *testing.T) {
+	srv := punduntest.NewServer()
+	defer srv.Close()
+	conf := Config{TLS: srv.ClientTLS(), RequestTimeout: 5 * time.Second}
+	if _, err := ConnectWithConfig(srv.Addr, "admin", "wrong", conf); err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+	s, err := ConnectWithConfig(srv.Addr, "admin", "admin", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Disconnect(s)
+
+	opts := map[string]interface{}{"comparator": "ascending"}
+	if _, err := CreateTable(s, "fake", []string{"id", "ts"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateTable(s, "fake", []string{"id"}, nil); !errors.Is(err, ErrTableExists) {
+		t.Fatalf("expected ErrTableExists, got %v", err)
+	}
+	for i := 3; i > 0; i-- {
+		key := map[string]interface{}{"id": "a", "ts": i}
+		if _, err := Write(s, "fake", key, map[string]interface{}{"n": i, "text": "the quick fox"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cols, err := Read(s, "fake", map[string]interface{}{"id": "a", "ts": 2})
+	if err != nil || cols["n"] != int64(2) {
+		t.Fatalf("unexpected read %v, %v", cols, err)
+	}
+	if _, err := Read(s, "fake", map[string]interface{}{"id": "b", "ts": 2}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := Read(s, "nope", map[string]interface{}{"id": "a"}); !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("expected ErrTableNotFound, got %v", err)
+	}
+
+	kvl, err := ReadRange(s, "fake", map[string]interface{}{"id": "a", "ts": 0},
+		map[string]interface{}{"id": "a", "ts": 2}, 10)
+	if err != nil || len(kvl.List) != 2 || kvl.List[0].Key["ts"] != int64(1) {
+		t.Fatalf("unexpected range %v, %v", kvl, err)
+	}
+	it, err := First(s, "fake")
+	if err != nil || it.Kvp.Key["ts"] != int64(1) {
+		t.Fatalf("unexpected first %v, %v", it, err)
+	}
+	for want := int64(2); want <= 3; want++ {
+		kvp, err := Next(s, it.It)
+		if err != nil || kvp.Key["ts"] != want {
+			t.Fatalf("unexpected next %v, %v", kvp, err)
+		}
+	}
+	if _, err := Next(s, it.It); !errors.Is(err, ErrEndOfTable) {
+		t.Fatalf("expected ErrEndOfTable, got %v", err)
+	}
+
+	index := []IndexConfig{{Column: "text", Options: IndexOptions{TokenFilter: TokenFilter{Stats: POSITION}}}}
+	if _, err := AddIndex(s, "fake", index); err != nil {
+		t.Fatal(err)
+	}
+	res, err := IndexRead(s, "fake", "text", "Quick Fox", PostingFilter{MaxPostings: 2})
+	if postings, ok := res.([]Posting); err != nil || !ok || len(postings) != 2 || postings[0].Position != 2 {
+		t.Fatalf("unexpected postings %v, %v", res, err)
+	}
+
+	info, err := DescribeTable(s, "fake", nil)
+	if err != nil || info.Comparator != "ascending" || info.Size != 3 || info.Indexes[0].Column != "text" {
+		t.Fatalf("unexpected info %+v, %v", info, err)
+	}
+	if _, err := DeleteTable(s, "fake"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngineSemantics(t *testing.T) {
+	e := punduntest.NewEngine()
+	var elapsed int64
+	start := time.Now()
+	e.Now = func() time.Time {
+		return start.Add(time.Duration(atomic.LoadInt64(&elapsed)))
+	}
+	s := ConnectConn(punduntest.Pipe(e), Config{RequestTimeout: 5 * time.Second})
+	defer Disconnect(s)
+
+	if _, err := CreateTable(s, "desc", []string{"id", "ts"}, map[string]interface{}{"ttl": 60}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		for ts := 1; ts <= 5; ts++ {
+			key := map[string]interface{}{"id": id, "ts": ts}
+			if _, err := Write(s, "desc", key, map[string]interface{}{"v": ts}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The default comparator is descending: "b" precedes "a" and
+	// larger timestamps come first.
+	it, err := First(s, "desc")
+	if err != nil || it.Kvp.Key["id"] != "b" || it.Kvp.Key["ts"] != int64(5) {
+		t.Fatalf("unexpected first %v, %v", it.Kvp, err)
+	}
+	if _, err := Prev(s, it.It); !errors.Is(err, ErrEndOfTable) {
+		t.Fatalf("expected ErrEndOfTable, got %v", err)
+	}
+
+	var seen []int64
+	skey := map[string]interface{}{"id": "a", "ts": 5}
+	ekey := map[string]interface{}{"id": "a", "ts": 0}
+	for {
+		kvl, err := ReadRange(s, "desc", skey, ekey, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kvp := range kvl.List {
+			seen = append(seen, kvp.Key["ts"].(int64))
+		}
+		if len(kvl.Continuation) == 0 {
+			break
+		}
+		skey = kvl.Continuation
+	}
+	if !reflect.DeepEqual(seen, []int64{5, 4, 3, 2, 1}) {
+		t.Fatalf("unexpected range %v", seen)
+	}
+	kvl, err := ReadRangeN(s, "desc", map[string]interface{}{"id": "b", "ts": 1}, 1)
+	if err != nil || len(kvl.List) != 1 || kvl.Continuation["id"] != "a" || kvl.Continuation["ts"] != int64(5) {
+		t.Fatalf("unexpected range n %v, %v", kvl, err)
+	}
+
+	threshold, setValue := uint32(6), uint32(0)
+	key := map[string]interface{}{"id": "a", "ts": 5}
+	op := []UpdateOperation{{Field: "v", Instruction: Increment, Value: 1, Threshold: &threshold, SetValue: &setValue}}
+	if res, err := Update(s, "desc", key, op); err != nil || res["v"] != int64(6) {
+		t.Fatalf("unexpected update %v, %v", res, err)
+	}
+	if res, err := Update(s, "desc", key, op); err != nil || res["v"] != int64(0) {
+		t.Fatalf("expected the set value, got %v, %v", res, err)
+	}
+
+	atomic.StoreInt64(&elapsed, int64(61*time.Second))
+	if _, err := Read(s, "desc", map[string]interface{}{"id": "a", "ts": 4}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired row, got %v", err)
+	}
+	if _, err := First(s, "desc"); !errors.Is(err, ErrEndOfTable) {
+		t.Fatalf("expected an empty table, got %v", err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := punduntest.NewServer()
+	defer srv.Close()
+	rec := punduntest.NewRecorder()
+	conf := Config{TLS: srv.ClientTLS(), RequestTimeout: 5 * time.Second, WrapConn: rec.Wrap}
+	s, err := ConnectWithConfig(srv.Addr, "admin", "admin", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := map[string]interface{}{"a": "x", "b": int64(1), "c": true, "d": 2.5}
+	columns := map[string]interface{}{"n": int64(1), "s": "text", "l": []interface{}{"x", int64(2)}}
+	session := func(s Session) (map[string]interface{}, KVL, error) {
+		if _, err := CreateTable(s, "rr", []string{"a", "b", "c", "d"}, nil); err != nil {
+			return nil, KVL{}, err
+		}
+		if _, err := Write(s, "rr", key, columns); err != nil {
+			return nil, KVL{}, err
+		}
+		cols, err := Read(s, "rr", key)
+		if err != nil {
+			return nil, KVL{}, err
+		}
+		kvl, err := ReadRangeN(s, "rr", key, 10)
+		if err != nil {
+			return nil, KVL{}, err
+		}
+		_, err = Read(s, "rr", map[string]interface{}{"a": "y", "b": int64(1), "c": true, "d": 2.5})
+		return cols, kvl, err
+	}
+	cols, kvl, err := session(s)
+	Disconnect(s)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound while recording, got %v", err)
+	}
+
+	path := t.TempDir() + "/cassette.json"
+	if err := rec.Cassette().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	cassette, err := punduntest.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cassette.Interactions); n != 5 || cassette.Interactions[2].Procedure != "Read" {
+		t.Fatalf("unexpected cassette %+v", cassette.Interactions)
+	}
+
+	// Field order differs between runs as maps are encoded in random
+	// order, so replay several sessions.
+	for i := 0; i < 3; i++ {
+		replayer, err := punduntest.NewReplayer(cassette)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs := ConnectConn(replayer.Conn(), Config{RequestTimeout: 5 * time.Second})
+		rcols, rkvl, err := session(rs)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the recorded error, got %v", err)
+		}
+		if !reflect.DeepEqual(rcols, cols) || !reflect.DeepEqual(rkvl, kvl) {
+			t.Fatalf("replay differs: %v %v, want %v %v", rcols, rkvl, cols, kvl)
+		}
+		if _, err := ListTables(rs); err == nil || len(replayer.Misses()) != 1 {
+			t.Fatalf("expected a miss, got %v, %v", err, replayer.Misses())
+		}
+		Disconnect(rs)
+	}
+}
+
+// echoConn answers each write with the written bytes and returns from
+// Write only once the answer has been read.
+type echoConn struct {
+	net.Conn
+	in       chan []byte
+	consumed chan struct{}
+}
+
+func (c *echoConn) Write(p []byte) (int, error) {
+	c.in <- append([]byte(nil), p...)
+	<-c.consumed
+	return len(p), nil
+}
+
+func (c *echoConn) Read(p []byte) (int, error) {
+	return copy(p, <-c.in), nil
+}
+
+func TestRecorderFastResponse(t *testing.T) {
+	rec := punduntest.NewRecorder()
+	ec := &echoConn{in: make(chan []byte), consumed: make(chan struct{})}
+	conn := rec.Wrap(ec)
+	go func() {
+		conn.Read(make([]byte, 4096))
+		ec.consumed <- struct{}{}
+	}()
+	pdu, _ := proto.Marshal(&apollo.ApolloPdu{Procedure: &apollo.ApolloPdu_Read{Read: &apollo.Read{TableName: "t"}}})
+	frame := make([]byte, 6, 6+len(pdu))
+	binary.BigEndian.PutUint32(frame, uint32(2+len(pdu)))
+	binary.BigEndian.PutUint16(frame[4:], 7)
+	if _, err := conn.Write(append(frame, pdu...)); err != nil {
+		t.Fatal(err)
+	}
+	if i := rec.Cassette().Interactions; len(i) != 1 || i[0].Procedure != "Read" {
+		t.Fatalf("unexpected interactions %+v", i)
+	}
+}
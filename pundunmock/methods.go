@@ -0,0 +1,293 @@
+package pundunmock
+
+import (
+	"context"
+
+	"github.com/falkevik/pundun"
+)
+
+// CreateTable is the mock counterpart of the CreateTable procedure.
+func (m *Mock) CreateTable(tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	return m.CreateTableContext(context.Background(), tableName, key, options)
+}
+
+// CreateTableContext is the mock counterpart of CreateTableContext.
+func (m *Mock) CreateTableContext(ctx context.Context, tableName string, key []string, options map[string]interface{}) (interface{}, error) {
+	res, err := m.call("CreateTable", []interface{}{tableName, key, options}, func(c pundun.Client) (interface{}, error) {
+		return c.CreateTableContext(ctx, tableName, key, options)
+	})
+	return result[interface{}](res, err)
+}
+
+// CreateTableWithOptions is the mock counterpart of the CreateTableWithOptions procedure.
+func (m *Mock) CreateTableWithOptions(tableName string, key []string, opts pundun.TableOptions) (interface{}, error) {
+	return m.CreateTableWithOptionsContext(context.Background(), tableName, key, opts)
+}
+
+// CreateTableWithOptionsContext is the mock counterpart of CreateTableWithOptionsContext.
+func (m *Mock) CreateTableWithOptionsContext(ctx context.Context, tableName string, key []string, opts pundun.TableOptions) (interface{}, error) {
+	res, err := m.call("CreateTableWithOptions", []interface{}{tableName, key, opts}, func(c pundun.Client) (interface{}, error) {
+		return c.CreateTableWithOptionsContext(ctx, tableName, key, opts)
+	})
+	return result[interface{}](res, err)
+}
+
+// DeleteTable is the mock counterpart of the DeleteTable procedure.
+func (m *Mock) DeleteTable(tableName string) (interface{}, error) {
+	return m.DeleteTableContext(context.Background(), tableName)
+}
+
+// DeleteTableContext is the mock counterpart of DeleteTableContext.
+func (m *Mock) DeleteTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	res, err := m.call("DeleteTable", []interface{}{tableName}, func(c pundun.Client) (interface{}, error) {
+		return c.DeleteTableContext(ctx, tableName)
+	})
+	return result[interface{}](res, err)
+}
+
+// OpenTable is the mock counterpart of the OpenTable procedure.
+func (m *Mock) OpenTable(tableName string) (interface{}, error) {
+	return m.OpenTableContext(context.Background(), tableName)
+}
+
+// OpenTableContext is the mock counterpart of OpenTableContext.
+func (m *Mock) OpenTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	res, err := m.call("OpenTable", []interface{}{tableName}, func(c pundun.Client) (interface{}, error) {
+		return c.OpenTableContext(ctx, tableName)
+	})
+	return result[interface{}](res, err)
+}
+
+// CloseTable is the mock counterpart of the CloseTable procedure.
+func (m *Mock) CloseTable(tableName string) (interface{}, error) {
+	return m.CloseTableContext(context.Background(), tableName)
+}
+
+// CloseTableContext is the mock counterpart of CloseTableContext.
+func (m *Mock) CloseTableContext(ctx context.Context, tableName string) (interface{}, error) {
+	res, err := m.call("CloseTable", []interface{}{tableName}, func(c pundun.Client) (interface{}, error) {
+		return c.CloseTableContext(ctx, tableName)
+	})
+	return result[interface{}](res, err)
+}
+
+// TableInfo is the mock counterpart of the TableInfo procedure.
+func (m *Mock) TableInfo(tableName string, attrs []string) (interface{}, error) {
+	return m.TableInfoContext(context.Background(), tableName, attrs)
+}
+
+// TableInfoContext is the mock counterpart of TableInfoContext.
+func (m *Mock) TableInfoContext(ctx context.Context, tableName string, attrs []string) (interface{}, error) {
+	res, err := m.call("TableInfo", []interface{}{tableName, attrs}, func(c pundun.Client) (interface{}, error) {
+		return c.TableInfoContext(ctx, tableName, attrs)
+	})
+	return result[interface{}](res, err)
+}
+
+// ListTables is the mock counterpart of the ListTables procedure.
+func (m *Mock) ListTables() ([]string, error) {
+	return m.ListTablesContext(context.Background())
+}
+
+// ListTablesContext is the mock counterpart of ListTablesContext.
+func (m *Mock) ListTablesContext(ctx context.Context) ([]string, error) {
+	res, err := m.call("ListTables", []interface{}{}, func(c pundun.Client) (interface{}, error) {
+		return c.ListTablesContext(ctx)
+	})
+	return result[[]string](res, err)
+}
+
+// Read is the mock counterpart of the Read procedure.
+func (m *Mock) Read(tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	return m.ReadContext(context.Background(), tableName, key)
+}
+
+// ReadContext is the mock counterpart of ReadContext.
+func (m *Mock) ReadContext(ctx context.Context, tableName string, key map[string]interface{}) (map[string]interface{}, error) {
+	res, err := m.call("Read", []interface{}{tableName, key}, func(c pundun.Client) (interface{}, error) {
+		return c.ReadContext(ctx, tableName, key)
+	})
+	return result[map[string]interface{}](res, err)
+}
+
+// Write is the mock counterpart of the Write procedure.
+func (m *Mock) Write(tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	return m.WriteContext(context.Background(), tableName, key, columns)
+}
+
+// WriteContext is the mock counterpart of WriteContext.
+func (m *Mock) WriteContext(ctx context.Context, tableName string, key, columns map[string]interface{}) (interface{}, error) {
+	res, err := m.call("Write", []interface{}{tableName, key, columns}, func(c pundun.Client) (interface{}, error) {
+		return c.WriteContext(ctx, tableName, key, columns)
+	})
+	return result[interface{}](res, err)
+}
+
+// Update is the mock counterpart of the Update procedure.
+func (m *Mock) Update(tableName string, key map[string]interface{}, upOps []pundun.UpdateOperation) (map[string]interface{}, error) {
+	return m.UpdateContext(context.Background(), tableName, key, upOps)
+}
+
+// UpdateContext is the mock counterpart of UpdateContext.
+func (m *Mock) UpdateContext(ctx context.Context, tableName string, key map[string]interface{}, upOps []pundun.UpdateOperation) (map[string]interface{}, error) {
+	res, err := m.call("Update", []interface{}{tableName, key, upOps}, func(c pundun.Client) (interface{}, error) {
+		return c.UpdateContext(ctx, tableName, key, upOps)
+	})
+	return result[map[string]interface{}](res, err)
+}
+
+// Delete is the mock counterpart of the Delete procedure.
+func (m *Mock) Delete(tableName string, key map[string]interface{}) (interface{}, error) {
+	return m.DeleteContext(context.Background(), tableName, key)
+}
+
+// DeleteContext is the mock counterpart of DeleteContext.
+func (m *Mock) DeleteContext(ctx context.Context, tableName string, key map[string]interface{}) (interface{}, error) {
+	res, err := m.call("Delete", []interface{}{tableName, key}, func(c pundun.Client) (interface{}, error) {
+		return c.DeleteContext(ctx, tableName, key)
+	})
+	return result[interface{}](res, err)
+}
+
+// ReadRange is the mock counterpart of the ReadRange procedure.
+func (m *Mock) ReadRange(tableName string, skey, ekey map[string]interface{}, limit int) (pundun.KVL, error) {
+	return m.ReadRangeContext(context.Background(), tableName, skey, ekey, limit)
+}
+
+// ReadRangeContext is the mock counterpart of ReadRangeContext.
+func (m *Mock) ReadRangeContext(ctx context.Context, tableName string, skey, ekey map[string]interface{}, limit int) (pundun.KVL, error) {
+	res, err := m.call("ReadRange", []interface{}{tableName, skey, ekey, limit}, func(c pundun.Client) (interface{}, error) {
+		return c.ReadRangeContext(ctx, tableName, skey, ekey, limit)
+	})
+	return result[pundun.KVL](res, err)
+}
+
+// ReadRangeN is the mock counterpart of the ReadRangeN procedure.
+func (m *Mock) ReadRangeN(tableName string, skey map[string]interface{}, n int) (pundun.KVL, error) {
+	return m.ReadRangeNContext(context.Background(), tableName, skey, n)
+}
+
+// ReadRangeNContext is the mock counterpart of ReadRangeNContext.
+func (m *Mock) ReadRangeNContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (pundun.KVL, error) {
+	res, err := m.call("ReadRangeN", []interface{}{tableName, skey, n}, func(c pundun.Client) (interface{}, error) {
+		return c.ReadRangeNContext(ctx, tableName, skey, n)
+	})
+	return result[pundun.KVL](res, err)
+}
+
+// ReadRangeNTs is the mock counterpart of the ReadRangeNTs procedure.
+func (m *Mock) ReadRangeNTs(tableName string, skey map[string]interface{}, n int) (pundun.KVL, error) {
+	return m.ReadRangeNTsContext(context.Background(), tableName, skey, n)
+}
+
+// ReadRangeNTsContext is the mock counterpart of ReadRangeNTsContext.
+func (m *Mock) ReadRangeNTsContext(ctx context.Context, tableName string, skey map[string]interface{}, n int) (pundun.KVL, error) {
+	res, err := m.call("ReadRangeNTs", []interface{}{tableName, skey, n}, func(c pundun.Client) (interface{}, error) {
+		return c.ReadRangeNTsContext(ctx, tableName, skey, n)
+	})
+	return result[pundun.KVL](res, err)
+}
+
+// First is the mock counterpart of the First procedure.
+func (m *Mock) First(tableName string) (pundun.Iterator, error) {
+	return m.FirstContext(context.Background(), tableName)
+}
+
+// FirstContext is the mock counterpart of FirstContext.
+func (m *Mock) FirstContext(ctx context.Context, tableName string) (pundun.Iterator, error) {
+	res, err := m.call("First", []interface{}{tableName}, func(c pundun.Client) (interface{}, error) {
+		return c.FirstContext(ctx, tableName)
+	})
+	return result[pundun.Iterator](res, err)
+}
+
+// Last is the mock counterpart of the Last procedure.
+func (m *Mock) Last(tableName string) (pundun.Iterator, error) {
+	return m.LastContext(context.Background(), tableName)
+}
+
+// LastContext is the mock counterpart of LastContext.
+func (m *Mock) LastContext(ctx context.Context, tableName string) (pundun.Iterator, error) {
+	res, err := m.call("Last", []interface{}{tableName}, func(c pundun.Client) (interface{}, error) {
+		return c.LastContext(ctx, tableName)
+	})
+	return result[pundun.Iterator](res, err)
+}
+
+// Seek is the mock counterpart of the Seek procedure.
+func (m *Mock) Seek(tableName string, key map[string]interface{}) (pundun.Iterator, error) {
+	return m.SeekContext(context.Background(), tableName, key)
+}
+
+// SeekContext is the mock counterpart of SeekContext.
+func (m *Mock) SeekContext(ctx context.Context, tableName string, key map[string]interface{}) (pundun.Iterator, error) {
+	res, err := m.call("Seek", []interface{}{tableName, key}, func(c pundun.Client) (interface{}, error) {
+		return c.SeekContext(ctx, tableName, key)
+	})
+	return result[pundun.Iterator](res, err)
+}
+
+// Next is the mock counterpart of the Next procedure.
+func (m *Mock) Next(it []byte) (pundun.KVP, error) {
+	return m.NextContext(context.Background(), it)
+}
+
+// NextContext is the mock counterpart of NextContext.
+func (m *Mock) NextContext(ctx context.Context, it []byte) (pundun.KVP, error) {
+	res, err := m.call("Next", []interface{}{it}, func(c pundun.Client) (interface{}, error) {
+		return c.NextContext(ctx, it)
+	})
+	return result[pundun.KVP](res, err)
+}
+
+// Prev is the mock counterpart of the Prev procedure.
+func (m *Mock) Prev(it []byte) (interface{}, error) {
+	return m.PrevContext(context.Background(), it)
+}
+
+// PrevContext is the mock counterpart of PrevContext.
+func (m *Mock) PrevContext(ctx context.Context, it []byte) (interface{}, error) {
+	res, err := m.call("Prev", []interface{}{it}, func(c pundun.Client) (interface{}, error) {
+		return c.PrevContext(ctx, it)
+	})
+	return result[interface{}](res, err)
+}
+
+// AddIndex is the mock counterpart of the AddIndex procedure.
+func (m *Mock) AddIndex(tableName string, configList []pundun.IndexConfig) (interface{}, error) {
+	return m.AddIndexContext(context.Background(), tableName, configList)
+}
+
+// AddIndexContext is the mock counterpart of AddIndexContext.
+func (m *Mock) AddIndexContext(ctx context.Context, tableName string, configList []pundun.IndexConfig) (interface{}, error) {
+	res, err := m.call("AddIndex", []interface{}{tableName, configList}, func(c pundun.Client) (interface{}, error) {
+		return c.AddIndexContext(ctx, tableName, configList)
+	})
+	return result[interface{}](res, err)
+}
+
+// RemoveIndex is the mock counterpart of the RemoveIndex procedure.
+func (m *Mock) RemoveIndex(tableName string, columns []string) (interface{}, error) {
+	return m.RemoveIndexContext(context.Background(), tableName, columns)
+}
+
+// RemoveIndexContext is the mock counterpart of RemoveIndexContext.
+func (m *Mock) RemoveIndexContext(ctx context.Context, tableName string, columns []string) (interface{}, error) {
+	res, err := m.call("RemoveIndex", []interface{}{tableName, columns}, func(c pundun.Client) (interface{}, error) {
+		return c.RemoveIndexContext(ctx, tableName, columns)
+	})
+	return result[interface{}](res, err)
+}
+
+// IndexRead is the mock counterpart of the IndexRead procedure.
+func (m *Mock) IndexRead(tableName string, columnName string, term string, pf pundun.PostingFilter) (interface{}, error) {
+	return m.IndexReadContext(context.Background(), tableName, columnName, term, pf)
+}
+
+// IndexReadContext is the mock counterpart of IndexReadContext.
+func (m *Mock) IndexReadContext(ctx context.Context, tableName string, columnName string, term string, pf pundun.PostingFilter) (interface{}, error) {
+	res, err := m.call("IndexRead", []interface{}{tableName, columnName, term, pf}, func(c pundun.Client) (interface{}, error) {
+		return c.IndexReadContext(ctx, tableName, columnName, term, pf)
+	})
+	return result[interface{}](res, err)
+}
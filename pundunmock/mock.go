@@ -0,0 +1,133 @@
+// Package pundunmock provides fakes of pundun.Client for unit tests: a
+// Mock that records calls and answers them with stubs, and an in-memory
+// session backed by a punduntest.Engine.
+package pundunmock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/falkevik/pundun"
+	"github.com/falkevik/pundun/punduntest"
+)
+
+// ErrNotStubbed is returned by a Mock for calls to a method that has no
+// stub when the mock has no backing client.
+var ErrNotStubbed = errors.New("pundunmock: method not stubbed")
+
+// Call is a call recorded by a Mock. Method is the procedure name without
+// the Context suffix and Args holds the arguments after the context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Stub answers a call to a Mock. The result must have the method's result
+// type, e.g. pundun.KVL for ReadRange; other values are returned as the
+// zero value.
+type Stub func(args []interface{}) (interface{}, error)
+
+// Return returns a stub that always answers with res and err.
+func Return(res interface{}, err error) Stub {
+	return func([]interface{}) (interface{}, error) {
+		return res, err
+	}
+}
+
+// Mock is a pundun.Client that records every call. Calls are answered by
+// the stub set for the method, or else passed on to Client.
+type Mock struct {
+	// Client, when not nil, answers the calls without a stub.
+	Client pundun.Client
+
+	mu    sync.Mutex
+	calls []Call
+	stubs map[string]Stub
+}
+
+var _ pundun.Client = (*Mock)(nil)
+
+// NewMock returns a mock passing calls without a stub on to c, which may
+// be nil.
+func NewMock(c pundun.Client) *Mock {
+	return &Mock{Client: c}
+}
+
+// On sets the stub for method, named without the Context suffix. A nil
+// stub removes it.
+func (m *Mock) On(method string, stub Stub) *Mock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stubs == nil {
+		m.stubs = make(map[string]Stub)
+	}
+	if stub == nil {
+		delete(m.stubs, method)
+	} else {
+		m.stubs[method] = stub
+	}
+	return m
+}
+
+// Calls returns the recorded calls in order. When methods are given only
+// calls to those methods are returned.
+func (m *Mock) Calls(methods ...string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := make([]Call, 0, len(m.calls))
+	for _, c := range m.calls {
+		if len(methods) == 0 || contains(methods, c.Method) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the recorded calls. Stubs are kept.
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+}
+
+// call records a call and answers it with the method's stub or, failing
+// that, with next on the backing client.
+func (m *Mock) call(method string, args []interface{}, next func(pundun.Client) (interface{}, error)) (interface{}, error) {
+	m.mu.Lock()
+	m.calls = append(m.calls, Call{method, args})
+	stub := m.stubs[method]
+	client := m.Client
+	m.mu.Unlock()
+	if stub != nil {
+		return stub(args)
+	}
+	if client == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotStubbed, method)
+	}
+	return next(client)
+}
+
+func result[T any](res interface{}, err error) (T, error) {
+	v, _ := res.(T)
+	return v, err
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMemory returns a session served by e, or by a new engine when e is
+// nil, over an in-memory connection. No network is used. Close it with
+// pundun.Disconnect.
+func NewMemory(e *punduntest.Engine) pundun.Session {
+	if e == nil {
+		e = punduntest.NewEngine()
+	}
+	return pundun.ConnectConn(punduntest.Pipe(e), pundun.Config{})
+}
@@ -0,0 +1,65 @@
+package pundunmock
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/falkevik/pundun"
+)
+
+// rename is application code written against pundun.Client.
+func rename(c pundun.Client, id, name string) error {
+	key := map[string]interface{}{"id": id}
+	cols, err := c.Read("users", key)
+	if err != nil {
+		return err
+	}
+	cols["name"] = name
+	_, err = c.Write("users", key, cols)
+	return err
+}
+
+func TestMock(t *testing.T) {
+	mem := NewMemory(nil)
+	defer pundun.Disconnect(mem)
+	m := NewMock(mem)
+	if _, err := m.CreateTable("users", []string{"id"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	key := map[string]interface{}{"id": "1"}
+	if _, err := m.Write("users", key, map[string]interface{}{"name": "ada", "age": 36}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rename(m, "1", "grace"); err != nil {
+		t.Fatal(err)
+	}
+	cols, err := mem.Read("users", key)
+	if err != nil || cols["name"] != "grace" || cols["age"] != int64(36) {
+		t.Fatalf("unexpected row %v, %v", cols, err)
+	}
+	writes := m.Calls("Write")
+	if len(writes) != 2 || !reflect.DeepEqual(writes[1].Args[:2], []interface{}{"users", key}) {
+		t.Fatalf("unexpected writes %+v", writes)
+	}
+	if len(m.Calls()) != 4 {
+		t.Fatalf("unexpected calls %+v", m.Calls())
+	}
+
+	m.Reset()
+	m.On("Read", Return(nil, pundun.ErrNotFound))
+	if err := rename(m, "1", "ada"); !errors.Is(err, pundun.ErrNotFound) {
+		t.Fatalf("expected the stubbed error, got %v", err)
+	}
+	if calls := m.Calls(); len(calls) != 1 || calls[0].Method != "Read" {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	m.On("ReadRange", Return(pundun.KVL{List: []pundun.KVP{{Key: key}}}, nil))
+	if kvl, err := m.ReadRange("users", key, key, 1); err != nil || len(kvl.List) != 1 {
+		t.Fatalf("unexpected range %v, %v", kvl, err)
+	}
+
+	if _, err := NewMock(nil).ListTables(); !errors.Is(err, ErrNotStubbed) {
+		t.Fatalf("expected ErrNotStubbed, got %v", err)
+	}
+}
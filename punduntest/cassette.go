@@ -0,0 +1,325 @@
+package punduntest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pundunlabs/apollo"
+)
+
+// A Cassette holds recorded request and response pdus. It is saved as
+// JSON, with the pdus in their wire encoding.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it received after Elapsed.
+// Procedure names the request's procedure, e.g. "Read", for readers of
+// the file; it is not used for matching.
+type Interaction struct {
+	Procedure string        `json:"procedure"`
+	Request   []byte        `json:"request"`
+	Response  []byte        `json:"response"`
+	Elapsed   time.Duration `json:"elapsed"`
+}
+
+// LoadCassette reads a cassette saved by Save.
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("punduntest: %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cassette to path.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Recorder records the traffic of the connections it wraps. Set Wrap as
+// pundun.Config.WrapConn to record a session:
+//
+//	rec := punduntest.NewRecorder()
+//	s, err := pundun.ConnectWithConfig(host, user, pass,
+//		pundun.Config{WrapConn: rec.Wrap})
+//	...
+//	rec.Cassette().Save("testdata/session.json")
+type Recorder struct {
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a recorder without interactions.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Cassette returns the interactions recorded so far, in the order the
+// responses were received. Requests that got no response are left out.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.interactions...)}
+}
+
+// Wrap returns conn with its traffic recorded.
+func (r *Recorder) Wrap(conn net.Conn) net.Conn {
+	return &recordingConn{Conn: conn, r: r, pending: make(map[uint16]pendingRequest)}
+}
+
+type pendingRequest struct {
+	pdu  []byte
+	sent time.Time
+}
+
+// recordingConn splits the byte streams of a session into frames and
+// pairs requests with responses by their correlation id.
+type recordingConn struct {
+	net.Conn
+	r *Recorder
+
+	mu      sync.Mutex
+	out     []byte
+	in      []byte
+	pending map[uint16]pendingRequest
+}
+
+// Write records the complete requests in p before sending them, so that
+// a response read right away finds its request pending.
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.out = append(c.out, p...)
+	for {
+		var frame []byte
+		if frame, c.out = nextFrame(c.out); frame == nil {
+			break
+		}
+		cid := binary.BigEndian.Uint16(frame)
+		c.pending[cid] = pendingRequest{frame[2:], time.Now()}
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.in = append(c.in, p[:n]...)
+	for {
+		var frame []byte
+		if frame, c.in = nextFrame(c.in); frame == nil {
+			return n, err
+		}
+		cid := binary.BigEndian.Uint16(frame)
+		req, ok := c.pending[cid]
+		if !ok {
+			continue
+		}
+		delete(c.pending, cid)
+		c.r.add(Interaction{
+			Procedure: procedureName(req.pdu),
+			Request:   req.pdu,
+			Response:  frame[2:],
+			Elapsed:   time.Since(req.sent),
+		})
+	}
+}
+
+func (r *Recorder) add(i Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, i)
+}
+
+// nextFrame cuts the first complete frame with a correlation id off buf.
+// It returns a nil frame when buf holds no complete frame.
+func nextFrame(buf []byte) ([]byte, []byte) {
+	if len(buf) < 4 {
+		return nil, buf
+	}
+	n := int(binary.BigEndian.Uint32(buf))
+	if len(buf) < 4+n {
+		return nil, buf
+	}
+	frame := append([]byte(nil), buf[4:4+n]...)
+	rest := append(buf[:0:0], buf[4+n:]...)
+	if len(frame) < 2 {
+		return nextFrame(rest)
+	}
+	return frame, rest
+}
+
+func procedureName(pduBin []byte) string {
+	pdu := &apollo.ApolloPdu{}
+	if err := proto.Unmarshal(pduBin, pdu); err != nil || pdu.GetProcedure() == nil {
+		return ""
+	}
+	name := reflect.TypeOf(pdu.GetProcedure()).Elem().Name()
+	return strings.TrimPrefix(name, "ApolloPdu_")
+}
+
+// Replayer answers requests with the responses recorded on a cassette.
+// A request is matched by its decoded procedure and arguments, so the
+// order of key and column fields and the transaction id do not matter.
+// Recorded interactions are used in order; when all interactions for a
+// request are used up, the last one is repeated. Requests without a
+// recording are answered with a "{error,not_recorded}" error.
+type Replayer struct {
+	// Delay, when set, delays each response by its recorded time.
+	Delay bool
+
+	mu      sync.Mutex
+	entries map[string][]*replayEntry
+	misses  []string
+}
+
+type replayEntry struct {
+	response []byte
+	elapsed  time.Duration
+	used     bool
+}
+
+// NewReplayer returns a replayer for the interactions on c.
+func NewReplayer(c *Cassette) (*Replayer, error) {
+	r := &Replayer{entries: make(map[string][]*replayEntry)}
+	for _, i := range c.Interactions {
+		pdu := &apollo.ApolloPdu{}
+		if err := proto.Unmarshal(i.Request, pdu); err != nil {
+			return nil, fmt.Errorf("punduntest: %s request: %w", i.Procedure, err)
+		}
+		key := requestKey(pdu)
+		r.entries[key] = append(r.entries[key], &replayEntry{i.Response, i.Elapsed, false})
+	}
+	return r, nil
+}
+
+// Conn returns the client end of a connection served by the replayer,
+// for use with pundun.ConnectConn.
+func (r *Replayer) Conn() net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		serveFrames(server, r.handle)
+	}()
+	return client
+}
+
+// Misses returns the requests that had no recording, in a readable form.
+func (r *Replayer) Misses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.misses...)
+}
+
+func (r *Replayer) handle(req *apollo.ApolloPdu) *apollo.ApolloPdu {
+	key := requestKey(req)
+	r.mu.Lock()
+	var entry *replayEntry
+	for _, e := range r.entries[key] {
+		entry = e
+		if !e.used {
+			break
+		}
+	}
+	if entry == nil {
+		r.misses = append(r.misses, key)
+	} else {
+		entry.used = true
+	}
+	r.mu.Unlock()
+
+	if entry == nil {
+		return errorPdu(req, &apollo.Error{Error: &apollo.Error_Misc{Misc: "{error,not_recorded}"}})
+	}
+	resp := &apollo.ApolloPdu{}
+	if err := proto.Unmarshal(entry.response, resp); err != nil {
+		return errorPdu(req, &apollo.Error{Error: &apollo.Error_Protocol{Protocol: "{error,invalid_recording}"}})
+	}
+	resp.TransactionId = req.GetTransactionId()
+	if r.Delay {
+		time.Sleep(entry.elapsed)
+	}
+	return resp
+}
+
+// requestKey returns a canonical form of the procedure in pdu. Lists of
+// fields are sorted by name and maps by key.
+func requestKey(pdu *apollo.ApolloPdu) string {
+	var b strings.Builder
+	writeCanonical(&b, reflect.ValueOf(pdu.GetProcedure()))
+	return b.String()
+}
+
+var fieldsType = reflect.TypeOf([]*apollo.Field(nil))
+
+func writeCanonical(b *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		writeCanonical(b, v.Elem())
+	case reflect.Struct:
+		b.WriteString(v.Type().Name() + "{")
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() || strings.HasPrefix(f.Name, "XXX_") {
+				continue
+			}
+			b.WriteString(f.Name + ":")
+			writeCanonical(b, v.Field(i))
+			b.WriteString(" ")
+		}
+		b.WriteString("}")
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			fmt.Fprintf(b, "%x", v.Bytes())
+			return
+		}
+		elems := make([]string, v.Len())
+		for i := range elems {
+			var eb strings.Builder
+			writeCanonical(&eb, v.Index(i))
+			elems[i] = eb.String()
+		}
+		if v.Type() == fieldsType {
+			sort.Strings(elems)
+		}
+		b.WriteString("[" + strings.Join(elems, " ") + "]")
+	case reflect.Map:
+		elems := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var eb strings.Builder
+			fmt.Fprintf(&eb, "%v:", iter.Key().Interface())
+			writeCanonical(&eb, iter.Value())
+			elems = append(elems, eb.String())
+		}
+		sort.Strings(elems)
+		b.WriteString("map[" + strings.Join(elems, " ") + "]")
+	case reflect.String:
+		fmt.Fprintf(b, "%q", v.String())
+	default:
+		fmt.Fprintf(b, "%v", v.Interface())
+	}
+}
@@ -0,0 +1,631 @@
+package punduntest
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/falkevik/pundun/internal/order"
+	"github.com/pundunlabs/apollo"
+)
+
+// Engine is an in-memory store of pundun tables that answers apollo
+// request pdus the way a node does: rows are ordered by their key fields
+// in declared order under the table's comparator, ranges return a
+// continuation key when cut short by their limit, increments honour
+// thresholds and rows expire after the table's ttl. It is safe for
+// concurrent use.
+//
+// Besides serving a Server, an engine can back a session directly:
+//
+//	s := pundun.ConnectConn(punduntest.Pipe(punduntest.NewEngine()), pundun.Config{})
+type Engine struct {
+	// Now returns the current time, used for ttl expiry and posting
+	// timestamps. It defaults to time.Now and may be replaced, e.g. to
+	// expire rows without waiting.
+	Now func() time.Time
+
+	mu     sync.Mutex
+	tables map[string]*table
+	its    map[uint64]*iterator
+	lastIt uint64
+}
+
+// NewEngine returns an engine without tables.
+func NewEngine() *Engine {
+	return &Engine{
+		tables: make(map[string]*table),
+		its:    make(map[uint64]*iterator),
+	}
+}
+
+type table struct {
+	name    string
+	key     []string
+	opts    tableOptions
+	closed  bool
+	rows    []*row
+	indexes []*apollo.IndexConfig
+}
+
+type tableOptions struct {
+	typ               string
+	dataModel         string
+	comparator        string
+	hashingMethod     string
+	timeSeries        bool
+	distributed       bool
+	numOfShards       uint32
+	replicationFactor uint32
+	hashExclude       []string
+	ttl               uint32
+}
+
+// A row holds the key values in the order of the table's key fields.
+type row struct {
+	key     []*apollo.Value
+	columns []*apollo.Field
+	written time.Time
+}
+
+type iterator struct {
+	t   *table
+	key []*apollo.Value
+}
+
+// reason is an error reported to clients as "{error,<reason>}".
+type reason string
+
+func (r reason) Error() string { return "{error," + string(r) + "}" }
+
+const (
+	errNoTable     reason = "no_table"
+	errTableExists reason = "table_exists"
+	errTableClosed reason = "table_closed"
+	errNotFound    reason = "not_found"
+	errBadArg      reason = "badarg"
+	errInvalid     reason = "invalid"
+)
+
+// Handle serves the request in pdu and returns the response pdu carrying
+// the request's transaction id. Values in pdu are kept by the engine and
+// must not be modified afterwards.
+func (e *Engine) Handle(pdu *apollo.ApolloPdu) *apollo.ApolloPdu {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var res *apollo.Response
+	var err error
+	switch p := pdu.GetProcedure().(type) {
+	case *apollo.ApolloPdu_CreateTable:
+		res, err = e.createTable(p.CreateTable)
+	case *apollo.ApolloPdu_DeleteTable:
+		res, err = e.deleteTable(p.DeleteTable.GetTableName())
+	case *apollo.ApolloPdu_OpenTable:
+		res, err = e.setClosed(p.OpenTable.GetTableName(), false)
+	case *apollo.ApolloPdu_CloseTable:
+		res, err = e.setClosed(p.CloseTable.GetTableName(), true)
+	case *apollo.ApolloPdu_TableInfo:
+		res, err = e.tableInfo(p.TableInfo)
+	case *apollo.ApolloPdu_ListTables:
+		res, err = e.listTables()
+	case *apollo.ApolloPdu_Read:
+		res, err = e.read(p.Read)
+	case *apollo.ApolloPdu_Write:
+		res, err = e.write(p.Write)
+	case *apollo.ApolloPdu_Update:
+		res, err = e.update(p.Update)
+	case *apollo.ApolloPdu_Delete:
+		res, err = e.delete(p.Delete)
+	case *apollo.ApolloPdu_ReadRange:
+		res, err = e.readRange(p.ReadRange)
+	case *apollo.ApolloPdu_ReadRangeN:
+		r := p.ReadRangeN
+		res, err = e.readRangeN(r.GetTableName(), r.GetStartKey(), r.GetN())
+	case *apollo.ApolloPdu_ReadRangeNTs:
+		r := p.ReadRangeNTs
+		res, err = e.readRangeN(r.GetTableName(), r.GetStartKey(), r.GetN())
+	case *apollo.ApolloPdu_First:
+		res, err = e.first(p.First.GetTableName(), false)
+	case *apollo.ApolloPdu_Last:
+		res, err = e.first(p.Last.GetTableName(), true)
+	case *apollo.ApolloPdu_Seek:
+		res, err = e.seek(p.Seek)
+	case *apollo.ApolloPdu_Next:
+		res, err = e.step(p.Next.GetIt(), false)
+	case *apollo.ApolloPdu_Prev:
+		res, err = e.step(p.Prev.GetIt(), true)
+	case *apollo.ApolloPdu_AddIndex:
+		res, err = e.addIndex(p.AddIndex)
+	case *apollo.ApolloPdu_RemoveIndex:
+		res, err = e.removeIndex(p.RemoveIndex)
+	case *apollo.ApolloPdu_IndexRead:
+		res, err = e.indexRead(p.IndexRead)
+	default:
+		return errorPdu(pdu, &apollo.Error{Error: &apollo.Error_Protocol{Protocol: "{error,unknown_procedure}"}})
+	}
+	if err != nil {
+		return errorPdu(pdu, &apollo.Error{Error: &apollo.Error_Misc{Misc: err.Error()}})
+	}
+	return &apollo.ApolloPdu{
+		Version:       pduVersion(pdu),
+		TransactionId: pdu.GetTransactionId(),
+		Procedure:     &apollo.ApolloPdu_Response{Response: res},
+	}
+}
+
+func errorPdu(req *apollo.ApolloPdu, e *apollo.Error) *apollo.ApolloPdu {
+	return &apollo.ApolloPdu{
+		Version:       pduVersion(req),
+		TransactionId: req.GetTransactionId(),
+		Procedure:     &apollo.ApolloPdu_Error{Error: e},
+	}
+}
+
+func pduVersion(req *apollo.ApolloPdu) *apollo.Version {
+	if v := req.GetVersion(); v != nil {
+		return v
+	}
+	return &apollo.Version{Major: 0, Minor: 1}
+}
+
+func okResponse() *apollo.Response {
+	return &apollo.Response{Result: &apollo.Response_Ok{Ok: "ok"}}
+}
+
+func columnsResponse(fields []*apollo.Field) *apollo.Response {
+	return &apollo.Response{Result: &apollo.Response_Columns{Columns: &apollo.Fields{Fields: fields}}}
+}
+
+func (e *Engine) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+// openTable returns the named table if it exists and is open, with its
+// expired rows removed.
+func (e *Engine) openTable(name string) (*table, error) {
+	t, ok := e.tables[name]
+	if !ok {
+		return nil, errNoTable
+	}
+	if t.closed {
+		return nil, errTableClosed
+	}
+	t.expire(e.now())
+	return t, nil
+}
+
+func (e *Engine) createTable(c *apollo.CreateTable) (*apollo.Response, error) {
+	name := c.GetTableName()
+	if name == "" || len(c.GetKeys()) == 0 {
+		return nil, errBadArg
+	}
+	if _, ok := e.tables[name]; ok {
+		return nil, errTableExists
+	}
+	seen := make(map[string]bool)
+	for _, k := range c.GetKeys() {
+		if k == "" || seen[k] {
+			return nil, errBadArg
+		}
+		seen[k] = true
+	}
+	e.tables[name] = &table{
+		name: name,
+		key:  append([]string(nil), c.GetKeys()...),
+		opts: parseOptions(c.GetTableOptions()),
+	}
+	return okResponse(), nil
+}
+
+func (e *Engine) deleteTable(name string) (*apollo.Response, error) {
+	t, ok := e.tables[name]
+	if !ok {
+		return nil, errNoTable
+	}
+	delete(e.tables, name)
+	for id, it := range e.its {
+		if it.t == t {
+			delete(e.its, id)
+		}
+	}
+	return okResponse(), nil
+}
+
+func (e *Engine) setClosed(name string, closed bool) (*apollo.Response, error) {
+	t, ok := e.tables[name]
+	if !ok {
+		return nil, errNoTable
+	}
+	t.closed = closed
+	return okResponse(), nil
+}
+
+func (e *Engine) listTables() (*apollo.Response, error) {
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := &apollo.FieldNames{FieldNames: names}
+	return &apollo.Response{Result: &apollo.Response_StringList{StringList: list}}, nil
+}
+
+func (e *Engine) read(r *apollo.Read) (*apollo.Response, error) {
+	t, err := e.openTable(r.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(r.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	i, found := t.search(key)
+	if !found {
+		return nil, errNotFound
+	}
+	return columnsResponse(t.rows[i].columns), nil
+}
+
+func (e *Engine) write(w *apollo.Write) (*apollo.Response, error) {
+	t, err := e.openTable(w.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(w.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	t.put(&row{key: key, columns: w.GetColumns(), written: e.now()})
+	return okResponse(), nil
+}
+
+func (e *Engine) delete(d *apollo.Delete) (*apollo.Response, error) {
+	t, err := e.openTable(d.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(d.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	if i, found := t.search(key); found {
+		t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	}
+	return okResponse(), nil
+}
+
+// update applies the operations to a copy of the row, so that a failing
+// operation leaves the row unchanged. A missing row is created.
+func (e *Engine) update(u *apollo.Update) (*apollo.Response, error) {
+	t, err := e.openTable(u.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(u.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	r := &row{key: key}
+	if i, found := t.search(key); found {
+		r.columns = append(r.columns, t.rows[i].columns...)
+	}
+	updated := make([]*apollo.Field, 0, len(u.GetUpdateOperation()))
+	for _, op := range u.GetUpdateOperation() {
+		v, err := applyUpdate(r.column(op.GetField()), op)
+		if err != nil {
+			return nil, err
+		}
+		r.set(op.GetField(), v)
+		updated = append(updated, &apollo.Field{Name: op.GetField(), Value: v})
+	}
+	r.written = e.now()
+	t.put(r)
+	return columnsResponse(updated), nil
+}
+
+// applyUpdate returns the new value of a column holding cur, nil when
+// missing. A missing column starts from the default value, or from zero
+// when none is given. An incremented integer that exceeds the threshold
+// becomes the set value, or the threshold when no set value is given.
+func applyUpdate(cur *apollo.Value, op *apollo.UpdateOperation) (*apollo.Value, error) {
+	inst := op.GetUpdateInstruction()
+	if inst.GetInstruction() == apollo.UpdateInstruction_OVERWRITE {
+		return op.GetValue(), nil
+	}
+	if cur == nil {
+		cur = op.GetDefaultValue()
+	}
+	if cur == nil {
+		cur = intValue(0)
+	}
+	v, err := increment(cur, op.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	threshold, ok := uint32Bytes(inst.GetThreshold())
+	if i, isInt := v.GetType().(*apollo.Value_Int); ok && isInt && i.Int > int64(threshold) {
+		if set, ok := uint32Bytes(inst.GetSetValue()); ok {
+			return intValue(int64(set)), nil
+		}
+		return intValue(int64(threshold)), nil
+	}
+	return v, nil
+}
+
+// uint32Bytes decodes a 4 byte big endian integer; empty means unset.
+func uint32Bytes(b []byte) (uint32, bool) {
+	if len(b) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+func increment(v, by *apollo.Value) (*apollo.Value, error) {
+	switch x := v.GetType().(type) {
+	case *apollo.Value_Int:
+		switch y := by.GetType().(type) {
+		case *apollo.Value_Int:
+			return intValue(x.Int + y.Int), nil
+		case *apollo.Value_Double:
+			return &apollo.Value{Type: &apollo.Value_Double{Double: float64(x.Int) + y.Double}}, nil
+		}
+	case *apollo.Value_Double:
+		switch y := by.GetType().(type) {
+		case *apollo.Value_Int:
+			return &apollo.Value{Type: &apollo.Value_Double{Double: x.Double + float64(y.Int)}}, nil
+		case *apollo.Value_Double:
+			return &apollo.Value{Type: &apollo.Value_Double{Double: x.Double + y.Double}}, nil
+		}
+	}
+	return nil, errBadArg
+}
+
+func (e *Engine) readRange(rr *apollo.ReadRange) (*apollo.Response, error) {
+	t, err := e.openTable(rr.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	start, err := t.keyOf(rr.GetStartKey())
+	if err != nil {
+		return nil, err
+	}
+	end, err := t.keyOf(rr.GetEndKey())
+	if err != nil {
+		return nil, err
+	}
+	limit := int(rr.GetLimit())
+	list := make([]*apollo.KeyColumnsPair, 0)
+	var cont *apollo.KeyColumnsPair
+	for i := t.lowerBound(start); i < len(t.rows); i++ {
+		if t.compareKeys(t.rows[i].key, end) > 0 {
+			break
+		}
+		if limit > 0 && len(list) == limit {
+			cont = &apollo.KeyColumnsPair{Key: t.keyFields(t.rows[i].key)}
+			break
+		}
+		list = append(list, t.kcp(t.rows[i]))
+	}
+	return kclResponse(list, cont), nil
+}
+
+func (e *Engine) readRangeN(name string, startKey []*apollo.Field, n uint32) (*apollo.Response, error) {
+	t, err := e.openTable(name)
+	if err != nil {
+		return nil, err
+	}
+	start, err := t.keyOf(startKey)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*apollo.KeyColumnsPair, 0)
+	i := t.lowerBound(start)
+	for ; i < len(t.rows) && len(list) < int(n); i++ {
+		list = append(list, t.kcp(t.rows[i]))
+	}
+	var cont *apollo.KeyColumnsPair
+	if i < len(t.rows) {
+		cont = &apollo.KeyColumnsPair{Key: t.keyFields(t.rows[i].key)}
+	}
+	return kclResponse(list, cont), nil
+}
+
+// kclResponse returns a key columns list. cont holds the key to continue
+// from, nil when the range is complete.
+func kclResponse(list []*apollo.KeyColumnsPair, cont *apollo.KeyColumnsPair) *apollo.Response {
+	kcl := &apollo.KeyColumnsList{List: list, Continuation: cont}
+	return &apollo.Response{Result: &apollo.Response_KeyColumnsList{KeyColumnsList: kcl}}
+}
+
+// first positions a new iterator on the first row, or the last row when
+// last is set.
+func (e *Engine) first(name string, last bool) (*apollo.Response, error) {
+	t, err := e.openTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.rows) == 0 {
+		return nil, errInvalid
+	}
+	i := 0
+	if last {
+		i = len(t.rows) - 1
+	}
+	return e.newIterator(t, t.rows[i]), nil
+}
+
+// seek positions a new iterator on the first row at or after the key.
+func (e *Engine) seek(s *apollo.Seek) (*apollo.Response, error) {
+	t, err := e.openTable(s.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(s.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	i := t.lowerBound(key)
+	if i == len(t.rows) {
+		return nil, errInvalid
+	}
+	return e.newIterator(t, t.rows[i]), nil
+}
+
+func (e *Engine) newIterator(t *table, r *row) *apollo.Response {
+	e.lastIt++
+	e.its[e.lastIt] = &iterator{t: t, key: r.key}
+	it := make([]byte, 8)
+	binary.BigEndian.PutUint64(it, e.lastIt)
+	return kcpItResponse(t.kcp(r), it)
+}
+
+// step moves the iterator to the following row, or the preceding one when
+// back is set. Rows written or deleted since the last step are seen.
+func (e *Engine) step(itBin []byte, back bool) (*apollo.Response, error) {
+	if len(itBin) != 8 {
+		return nil, errInvalid
+	}
+	it, ok := e.its[binary.BigEndian.Uint64(itBin)]
+	if !ok {
+		return nil, errInvalid
+	}
+	if it.t.closed {
+		return nil, errTableClosed
+	}
+	t := it.t
+	t.expire(e.now())
+	var i int
+	if back {
+		i = t.lowerBound(it.key) - 1
+	} else {
+		i = t.upperBound(it.key)
+	}
+	if i < 0 || i >= len(t.rows) {
+		return nil, errInvalid
+	}
+	it.key = t.rows[i].key
+	return kcpItResponse(t.kcp(t.rows[i]), itBin), nil
+}
+
+func kcpItResponse(kcp *apollo.KeyColumnsPair, it []byte) *apollo.Response {
+	kcpIt := &apollo.KcpIt{KeyColumnsPair: kcp, It: it}
+	return &apollo.Response{Result: &apollo.Response_KcpIt{KcpIt: kcpIt}}
+}
+
+// keyOf returns the key values of fields in the order of the table's key.
+// Every key field must be given, and nothing else.
+func (t *table) keyOf(fields []*apollo.Field) ([]*apollo.Value, error) {
+	if len(fields) != len(t.key) {
+		return nil, errBadArg
+	}
+	key := make([]*apollo.Value, len(t.key))
+	for i, name := range t.key {
+		for _, f := range fields {
+			if f.GetName() == name {
+				key[i] = f.GetValue()
+			}
+		}
+		if key[i] == nil {
+			return nil, errBadArg
+		}
+	}
+	return key, nil
+}
+
+func (t *table) keyFields(key []*apollo.Value) []*apollo.Field {
+	fields := make([]*apollo.Field, len(key))
+	for i, v := range key {
+		fields[i] = &apollo.Field{Name: t.key[i], Value: v}
+	}
+	return fields
+}
+
+func (t *table) kcp(r *row) *apollo.KeyColumnsPair {
+	return &apollo.KeyColumnsPair{Key: t.keyFields(r.key), Columns: r.columns}
+}
+
+// compareKeys orders keys field by field in the declared key order,
+// reversed for tables with the descending comparator.
+func (t *table) compareKeys(a, b []*apollo.Value) int {
+	for i := range a {
+		if c := order.Compare(a[i], b[i]); c != 0 {
+			if t.opts.comparator == "descending" {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// lowerBound returns the index of the first row at or after key.
+func (t *table) lowerBound(key []*apollo.Value) int {
+	return sort.Search(len(t.rows), func(i int) bool {
+		return t.compareKeys(t.rows[i].key, key) >= 0
+	})
+}
+
+// upperBound returns the index of the first row after key.
+func (t *table) upperBound(key []*apollo.Value) int {
+	return sort.Search(len(t.rows), func(i int) bool {
+		return t.compareKeys(t.rows[i].key, key) > 0
+	})
+}
+
+func (t *table) search(key []*apollo.Value) (int, bool) {
+	i := t.lowerBound(key)
+	return i, i < len(t.rows) && t.compareKeys(t.rows[i].key, key) == 0
+}
+
+// expire removes the rows written more than the table's ttl before now.
+func (t *table) expire(now time.Time) {
+	if t.opts.ttl == 0 {
+		return
+	}
+	deadline := now.Add(-time.Duration(t.opts.ttl) * time.Second)
+	rows := t.rows[:0]
+	for _, r := range t.rows {
+		if r.written.After(deadline) {
+			rows = append(rows, r)
+		}
+	}
+	for i := len(rows); i < len(t.rows); i++ {
+		t.rows[i] = nil
+	}
+	t.rows = rows
+}
+
+// put stores r, replacing the row with the same key.
+func (t *table) put(r *row) {
+	i, found := t.search(r.key)
+	if found {
+		t.rows[i] = r
+		return
+	}
+	t.rows = append(t.rows, nil)
+	copy(t.rows[i+1:], t.rows[i:])
+	t.rows[i] = r
+}
+
+func (r *row) column(name string) *apollo.Value {
+	for _, f := range r.columns {
+		if f.GetName() == name {
+			return f.GetValue()
+		}
+	}
+	return nil
+}
+
+func (r *row) set(name string, v *apollo.Value) {
+	for i, f := range r.columns {
+		if f.GetName() == name {
+			r.columns[i] = &apollo.Field{Name: name, Value: v}
+			return
+		}
+	}
+	r.columns = append(r.columns, &apollo.Field{Name: name, Value: v})
+}
@@ -0,0 +1,182 @@
+package punduntest
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pundunlabs/apollo"
+)
+
+// Words removed by the "$english_stopwords" token filter entry.
+var englishStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if",
+	"in", "into", "is", "it", "no", "not", "of", "on", "or", "such",
+	"that", "the", "their", "then", "there", "these", "they", "this",
+	"to", "was", "will", "with",
+}
+
+func (e *Engine) addIndex(a *apollo.AddIndex) (*apollo.Response, error) {
+	t, err := e.openTable(a.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range a.GetConfig() {
+		if c.GetColumn() == "" {
+			return nil, errBadArg
+		}
+	}
+	for _, c := range a.GetConfig() {
+		t.removeIndex(c.GetColumn())
+		t.indexes = append(t.indexes, c)
+	}
+	return okResponse(), nil
+}
+
+func (e *Engine) removeIndex(r *apollo.RemoveIndex) (*apollo.Response, error) {
+	t, err := e.openTable(r.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range r.GetColumns() {
+		t.removeIndex(c)
+	}
+	return okResponse(), nil
+}
+
+func (t *table) removeIndex(column string) {
+	for i, c := range t.indexes {
+		if c.GetColumn() == column {
+			t.indexes = append(t.indexes[:i], t.indexes[i+1:]...)
+			return
+		}
+	}
+}
+
+// indexRead finds the rows whose indexed column contains the term. The
+// rows are tokenized on each read, so the index always reflects the
+// current table.
+func (e *Engine) indexRead(ir *apollo.IndexRead) (*apollo.Response, error) {
+	t, err := e.openTable(ir.GetTableName())
+	if err != nil {
+		return nil, err
+	}
+	var config *apollo.IndexConfig
+	for _, c := range t.indexes {
+		if c.GetColumn() == ir.GetColumnName() {
+			config = c
+		}
+	}
+	list := make([]*apollo.Posting, 0)
+	if config == nil {
+		return postingsResponse(list), nil
+	}
+	opts := config.GetOptions()
+	phrase := tokenize(ir.GetTerm(), opts)
+	filter := ir.GetFilter()
+	startTs, endTs := tsBound(filter.GetStartTs(), 0), tsBound(filter.GetEndTs(), ^uint32(0))
+	for _, r := range t.rows {
+		ts := uint32(r.written.Unix())
+		if ts < startTs || ts > endTs {
+			continue
+		}
+		v, ok := r.column(ir.GetColumnName()).GetType().(*apollo.Value_String_)
+		if !ok {
+			continue
+		}
+		freq, pos := match(tokenize(v.String_, opts), phrase)
+		if freq == 0 {
+			continue
+		}
+		p := &apollo.Posting{Key: t.keyFields(r.key), Timestamp: ts}
+		switch opts.GetTokenFilter().GetStats() {
+		case apollo.TokenStats_POSITION:
+			p.Position = pos
+			fallthrough
+		case apollo.TokenStats_FREQUENCY:
+			p.Frequency = freq
+		}
+		list = append(list, p)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if filter.GetSortBy() == apollo.SortBy_RELEVANCE && a.Frequency != b.Frequency {
+			return a.Frequency > b.Frequency
+		}
+		return a.Timestamp > b.Timestamp
+	})
+	if max := int(filter.GetMaxPostings()); max > 0 && len(list) > max {
+		list = list[:max]
+	}
+	return postingsResponse(list), nil
+}
+
+func postingsResponse(list []*apollo.Posting) *apollo.Response {
+	return &apollo.Response{Result: &apollo.Response_Postings{Postings: &apollo.Postings{List: list}}}
+}
+
+func tsBound(b []byte, def uint32) uint32 {
+	if len(b) != 4 {
+		return def
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// tokenize splits text into words at anything but letters and digits and
+// applies the token filter. Unicode normalization is not performed.
+func tokenize(text string, opts *apollo.IndexOptions) []string {
+	filter := opts.GetTokenFilter()
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	stop := make(map[string]bool)
+	for _, w := range filter.GetDelete() {
+		if w == "$english_stopwords" {
+			for _, s := range englishStopwords {
+				stop[s] = true
+			}
+		} else {
+			stop[transform(w, filter)] = true
+		}
+	}
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		w = transform(w, filter)
+		if !stop[w] {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+func transform(w string, filter *apollo.TokenFilter) string {
+	if filter.GetTransform() == apollo.TokenTransform_UPPERCASE {
+		return strings.ToUpper(w)
+	}
+	return strings.ToLower(w)
+}
+
+// match counts the occurrences of phrase in tokens and returns them with
+// the position of the first one, counted from 1.
+func match(tokens, phrase []string) (freq, pos uint32) {
+	if len(phrase) == 0 {
+		return 0, 0
+	}
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		found := true
+		for j, p := range phrase {
+			if tokens[i+j] != p {
+				found = false
+				break
+			}
+		}
+		if found {
+			if freq == 0 {
+				pos = uint32(i + 1)
+			}
+			freq++
+		}
+	}
+	return freq, pos
+}
@@ -0,0 +1,192 @@
+package punduntest
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pundunlabs/apollo"
+)
+
+var typeNames = map[apollo.Type]string{
+	apollo.Type_LEVELDB:           "leveldb",
+	apollo.Type_MEMLEVELDB:        "mem_leveldb",
+	apollo.Type_LEVELDBWRAPPED:    "leveldb_wrapped",
+	apollo.Type_MEMLEVELDBWRAPPED: "mem_leveldb_wrapped",
+	apollo.Type_LEVELDBTDA:        "leveldb_tda",
+	apollo.Type_MEMLEVELDBTDA:     "mem_leveldb_tda",
+	apollo.Type_ROCKSDB:           "rocksdb",
+}
+
+var dataModelNames = map[apollo.DataModel]string{
+	apollo.DataModel_KV:    "kv",
+	apollo.DataModel_ARRAY: "array",
+	apollo.DataModel_MAP:   "map",
+}
+
+var hashingMethodNames = map[apollo.HashingMethod]string{
+	apollo.HashingMethod_VIRTUALNODES: "virtual_nodes",
+	apollo.HashingMethod_CONSISTENT:   "consistent",
+	apollo.HashingMethod_UNIFORM:      "uniform",
+	apollo.HashingMethod_RENDEZVOUS:   "rendezvous",
+}
+
+// parseOptions reads the creation options, defaulting as pundun does.
+func parseOptions(opts []*apollo.TableOption) tableOptions {
+	o := tableOptions{
+		typ:               "rocksdb",
+		dataModel:         "array",
+		comparator:        "descending",
+		hashingMethod:     "uniform",
+		distributed:       true,
+		numOfShards:       1,
+		replicationFactor: 1,
+	}
+	for _, opt := range opts {
+		switch x := opt.GetOpt().(type) {
+		case *apollo.TableOption_Type:
+			o.typ = typeNames[x.Type]
+		case *apollo.TableOption_DataModel:
+			o.dataModel = dataModelNames[x.DataModel]
+		case *apollo.TableOption_Comparator:
+			if x.Comparator == apollo.Comparator_ASCENDING {
+				o.comparator = "ascending"
+			}
+		case *apollo.TableOption_HashingMethod:
+			o.hashingMethod = hashingMethodNames[x.HashingMethod]
+		case *apollo.TableOption_TimeSeries:
+			o.timeSeries = x.TimeSeries
+		case *apollo.TableOption_Distributed:
+			o.distributed = x.Distributed
+		case *apollo.TableOption_NumOfShards:
+			o.numOfShards = x.NumOfShards
+		case *apollo.TableOption_ReplicationFactor:
+			o.replicationFactor = x.ReplicationFactor
+		case *apollo.TableOption_HashExclude:
+			o.hashExclude = x.HashExclude.GetFieldNames()
+		case *apollo.TableOption_Ttl:
+			o.ttl = x.Ttl
+		}
+	}
+	return o
+}
+
+func (e *Engine) tableInfo(ti *apollo.TableInfo) (*apollo.Response, error) {
+	t, ok := e.tables[ti.GetTableName()]
+	if !ok {
+		return nil, errNoTable
+	}
+	t.expire(e.now())
+	attrs := t.info()
+	names := ti.GetAttributes()
+	if len(names) == 0 {
+		names = infoAttributes
+	}
+	fields := make([]*apollo.Field, 0, len(names))
+	for _, name := range names {
+		if v, ok := attrs[name]; ok {
+			fields = append(fields, &apollo.Field{Name: name, Value: v})
+		}
+	}
+	pl := &apollo.Fields{Fields: fields}
+	return &apollo.Response{Result: &apollo.Response_Proplist{Proplist: pl}}, nil
+}
+
+// Attributes reported when TableInfo asks for all of them.
+var infoAttributes = []string{
+	"name", "key", "columns", "type", "data_model", "comparator",
+	"time_series", "distributed", "num_of_shards", "hashing_method",
+	"replication_factor", "hash_exclude", "ttl", "index_on", "size",
+	"memory", "shards",
+}
+
+func (t *table) info() map[string]*apollo.Value {
+	o := t.opts
+	columns := make(map[string]bool)
+	memory := 0
+	for _, r := range t.rows {
+		for _, v := range r.key {
+			memory += valueSize(v)
+		}
+		for _, f := range r.columns {
+			columns[f.GetName()] = true
+			memory += len(f.GetName()) + valueSize(f.GetValue())
+		}
+	}
+	columnNames := make([]string, 0, len(columns))
+	for c := range columns {
+		columnNames = append(columnNames, c)
+	}
+	sort.Strings(columnNames)
+	shards := make([]*apollo.Value, o.numOfShards)
+	for i := range shards {
+		shards[i] = mapValue(map[string]*apollo.Value{
+			"name": stringValue(t.name + "_shard" + strconv.Itoa(i)),
+			"node": stringValue("pundun@localhost"),
+		})
+	}
+	indexes := make([]*apollo.Value, len(t.indexes))
+	for i, c := range t.indexes {
+		indexes[i] = mapValue(map[string]*apollo.Value{
+			"column":  stringValue(c.GetColumn()),
+			"options": indexOptionsValue(c.GetOptions()),
+		})
+	}
+	attrs := map[string]*apollo.Value{
+		"name":               stringValue(t.name),
+		"key":                stringsValue(t.key),
+		"columns":            stringsValue(columnNames),
+		"type":               stringValue(o.typ),
+		"data_model":         stringValue(o.dataModel),
+		"comparator":         stringValue(o.comparator),
+		"time_series":        boolValue(o.timeSeries),
+		"distributed":        boolValue(o.distributed),
+		"num_of_shards":      intValue(int64(o.numOfShards)),
+		"hashing_method":     stringValue(o.hashingMethod),
+		"replication_factor": intValue(int64(o.replicationFactor)),
+		"index_on":           listValue(indexes),
+		"size":               intValue(int64(len(t.rows))),
+		"memory":             intValue(int64(memory)),
+		"shards":             listValue(shards),
+	}
+	if o.hashExclude != nil {
+		attrs["hash_exclude"] = stringsValue(o.hashExclude)
+	}
+	if o.ttl != 0 {
+		attrs["ttl"] = intValue(int64(o.ttl))
+	}
+	return attrs
+}
+
+var charFilterNames = map[apollo.CharFilter]string{
+	apollo.CharFilter_NFC:  "nfc",
+	apollo.CharFilter_NFD:  "nfd",
+	apollo.CharFilter_NFKC: "nfkc",
+	apollo.CharFilter_NFKD: "nfkd",
+}
+
+var transformNames = map[apollo.TokenTransform]string{
+	apollo.TokenTransform_LOWERCASE: "lowercase",
+	apollo.TokenTransform_UPPERCASE: "uppercase",
+	apollo.TokenTransform_CASEFOLD:  "casefold",
+}
+
+var statsNames = map[apollo.TokenStats]string{
+	apollo.TokenStats_NOSTATS:   "nostats",
+	apollo.TokenStats_UNIQUE:    "unique",
+	apollo.TokenStats_FREQUENCY: "frequency",
+	apollo.TokenStats_POSITION:  "position",
+}
+
+func indexOptionsValue(o *apollo.IndexOptions) *apollo.Value {
+	f := o.GetTokenFilter()
+	return mapValue(map[string]*apollo.Value{
+		"char_filter": stringValue(charFilterNames[o.GetCharFilter()]),
+		"tokenizer":   stringValue("unicode_word_boundaries"),
+		"token_filter": mapValue(map[string]*apollo.Value{
+			"transform": stringValue(transformNames[f.GetTransform()]),
+			"add":       stringsValue(f.GetAdd()),
+			"delete":    stringsValue(f.GetDelete()),
+			"stats":     stringValue(statsNames[f.GetStats()]),
+		}),
+	})
+}
@@ -0,0 +1,145 @@
+package punduntest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strings"
+	"time"
+)
+
+// Number of PBKDF2 iterations announced to clients. Real nodes use more;
+// a low count keeps tests fast.
+const (
+	scramIterations = 4096
+	authTimeout     = 10 * time.Second
+)
+
+// authenticate runs the server side of a SCRAM exchange (RFC 5802) with
+// the client on conn. A failed exchange is answered with an "e=" message.
+func (s *Server) authenticate(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	clientFirst, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	msg := string(clientFirst)
+	if !strings.HasPrefix(msg, "n,,") {
+		return scramFail(conn, "channel-bindings-dont-match", errors.New("unsupported gs2 header"))
+	}
+	clientFirstBare := msg[3:]
+	attrs := scramAttrs(clientFirstBare)
+	user := strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs["n"])
+	clientNonce := attrs["r"]
+	if clientNonce == "" {
+		return scramFail(conn, "other-error", errors.New("missing client nonce"))
+	}
+	pass, ok := s.Users[user]
+	if !ok {
+		return scramFail(conn, "unknown-user", fmt.Errorf("unknown user %q", user))
+	}
+
+	salt := make([]byte, 16)
+	nonce := make([]byte, 18)
+	rand.Read(salt)
+	rand.Read(nonce)
+	combined := clientNonce + base64.StdEncoding.EncodeToString(nonce)
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d", combined,
+		base64.StdEncoding.EncodeToString(salt), scramIterations)
+	if err := writeFrame(conn, []byte(serverFirst)); err != nil {
+		return err
+	}
+
+	clientFinal, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	msg = string(clientFinal)
+	i := strings.LastIndex(msg, ",p=")
+	if i < 0 {
+		return scramFail(conn, "invalid-proof", errors.New("missing client proof"))
+	}
+	withoutProof := msg[:i]
+	attrs = scramAttrs(msg)
+	if attrs["r"] != combined {
+		return scramFail(conn, "other-error", errors.New("nonce mismatch"))
+	}
+	proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+	if err != nil {
+		return scramFail(conn, "invalid-proof", err)
+	}
+
+	salted := saltPassword(s.Hash, []byte(pass), salt, scramIterations)
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + withoutProof)
+	clientKey := hmacSum(s.Hash, salted, []byte("Client Key"))
+	storedKey := hashSum(s.Hash, clientKey)
+	signature := hmacSum(s.Hash, storedKey, authMessage)
+	if len(proof) != len(signature) {
+		return scramFail(conn, "invalid-proof", errAuth)
+	}
+	for i := range proof {
+		proof[i] ^= signature[i]
+	}
+	if subtle.ConstantTimeCompare(hashSum(s.Hash, proof), storedKey) != 1 {
+		return scramFail(conn, "invalid-proof", errAuth)
+	}
+	serverKey := hmacSum(s.Hash, salted, []byte("Server Key"))
+	verifier := hmacSum(s.Hash, serverKey, authMessage)
+	return writeFrame(conn, []byte("v="+base64.StdEncoding.EncodeToString(verifier)))
+}
+
+var errAuth = errors.New("authentication failed")
+
+func scramFail(conn net.Conn, msg string, err error) error {
+	writeFrame(conn, []byte("e="+msg))
+	return err
+}
+
+// scramAttrs splits a SCRAM message into its attributes.
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, a := range strings.Split(msg, ",") {
+		if len(a) >= 2 && a[1] == '=' {
+			attrs[a[:1]] = a[2:]
+		}
+	}
+	return attrs
+}
+
+// saltPassword is the Hi function of RFC 5802, i.e. PBKDF2 with HMAC
+// and a single block of output.
+func saltPassword(h func() hash.Hash, pass, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, pass)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+func hmacSum(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hashSum(h func() hash.Hash, data []byte) []byte {
+	d := h()
+	d.Write(data)
+	return d.Sum(nil)
+}
@@ -0,0 +1,287 @@
+// Package punduntest provides an in-process pundun node for tests.
+//
+// A Server listens on the loopback interface and speaks the pundun
+// client protocol: TLS, SCRAM authentication and apollo pdus framed by a
+// 4 byte length and a 2 byte correlation id. Requests are served from an
+// in-memory Engine, so a session made with pundun.ConnectWithConfig can
+// exercise the whole API without a real node:
+//
+//	srv := punduntest.NewServer()
+//	defer srv.Close()
+//	s, err := pundun.ConnectWithConfig(srv.Addr, "admin", "admin",
+//		pundun.Config{TLS: srv.ClientTLS()})
+package punduntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"hash"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pundunlabs/apollo"
+)
+
+// A Server is a pundun node listening on a system-chosen port on the
+// loopback interface.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr     string
+	Listener net.Listener
+
+	// Users maps the user names accepted by the server to their
+	// passwords. It may be changed before Start.
+	Users map[string]string
+	// Hash is the hash function of the SCRAM mechanism, SHA-1 unless
+	// set before Start.
+	Hash func() hash.Hash
+	// Engine serves the requests. It may be shared between servers.
+	Engine *Engine
+
+	tlsCert tls.Certificate
+	cert    *x509.Certificate
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer starts and returns a new server accepting the user "admin"
+// with the password "admin". The caller should call Close when finished.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new server that is listening but does
+// not serve connections until Start is called.
+func NewUnstartedServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if l, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic("punduntest: failed to listen on a port: " + err.Error())
+		}
+	}
+	return &Server{
+		Listener: l,
+		Users:    map[string]string{"admin": "admin"},
+		Engine:   NewEngine(),
+	}
+}
+
+// Start starts serving connections.
+func (s *Server) Start() {
+	if s.Addr != "" {
+		panic("punduntest: Server already started")
+	}
+	if s.Hash == nil {
+		s.Hash = sha1.New
+	}
+	if err := s.generateCert(); err != nil {
+		panic("punduntest: failed to generate certificate: " + err.Error())
+	}
+	s.Addr = s.Listener.Addr().String()
+	s.conns = make(map[net.Conn]bool)
+	conf := &tls.Config{Certificates: []tls.Certificate{s.tlsCert}}
+	l := tls.NewListener(s.Listener, conf)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if !s.track(conn, true) {
+				conn.Close()
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.track(conn, false)
+				s.serveConn(conn)
+			}()
+		}
+	}()
+}
+
+// Close shuts down the server and blocks until all connections are
+// closed and their requests served.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.Listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// CloseClientConnections closes the connections of all clients, e.g.
+// to test reconnecting sessions. The server keeps accepting new ones.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Certificate returns the self-signed certificate of the server.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// ClientTLS returns a TLS configuration that trusts the server's
+// certificate, for use as pundun.Config.TLS.
+func (s *Server) ClientTLS() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+func (s *Server) track(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+		conn.Close()
+	}
+	return true
+}
+
+// generateCert creates a self-signed certificate for the loopback
+// addresses and localhost.
+func (s *Server) generateCert() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"punduntest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	if s.cert, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+	s.tlsCert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return nil
+}
+
+// serveConn authenticates the client and serves its requests in order
+// until the connection is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	if err := s.authenticate(conn); err != nil {
+		log.Println("punduntest:", err)
+		return
+	}
+	ServeConn(conn, s.Engine)
+}
+
+// ServeConn serves the framed requests read from conn with e until conn
+// is closed. The client is expected to be authenticated already.
+func ServeConn(conn net.Conn, e *Engine) {
+	serveFrames(conn, e.Handle)
+}
+
+// serveFrames answers each request pdu read from conn with the pdu
+// returned by handle, under the request's correlation id.
+func serveFrames(conn net.Conn, handle func(*apollo.ApolloPdu) *apollo.ApolloPdu) {
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		if len(frame) < 2 {
+			return
+		}
+		cid := frame[:2]
+		pdu := &apollo.ApolloPdu{}
+		var resp *apollo.ApolloPdu
+		if err := proto.Unmarshal(frame[2:], pdu); err != nil {
+			resp = errorPdu(pdu, &apollo.Error{Error: &apollo.Error_Protocol{Protocol: "{error,invalid_request}"}})
+		} else {
+			resp = handle(pdu)
+		}
+		data, err := proto.Marshal(resp)
+		if err != nil {
+			return
+		}
+		if err := writeFrame(conn, append(cid[:2:2], data...)); err != nil {
+			return
+		}
+	}
+}
+
+// Pipe returns the client end of an in-memory connection served by e,
+// for use with pundun.ConnectConn. No authentication takes place.
+func Pipe(e *Engine) net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		ServeConn(server, e)
+	}()
+	return client
+}
+
+// readFrame reads a frame prefixed by its 4 byte big endian length.
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeFrame writes data prefixed by its length in a single write.
+func writeFrame(w io.Writer, data []byte) error {
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,54 @@
+package punduntest
+
+import "github.com/pundunlabs/apollo"
+
+func stringValue(s string) *apollo.Value {
+	return &apollo.Value{Type: &apollo.Value_String_{String_: s}}
+}
+
+func intValue(i int64) *apollo.Value {
+	return &apollo.Value{Type: &apollo.Value_Int{Int: i}}
+}
+
+func boolValue(b bool) *apollo.Value {
+	return &apollo.Value{Type: &apollo.Value_Boolean{Boolean: b}}
+}
+
+func stringsValue(l []string) *apollo.Value {
+	values := make([]*apollo.Value, len(l))
+	for i, s := range l {
+		values[i] = stringValue(s)
+	}
+	return listValue(values)
+}
+
+func listValue(values []*apollo.Value) *apollo.Value {
+	return &apollo.Value{Type: &apollo.Value_List{List: &apollo.ListValue{Values: values}}}
+}
+
+func mapValue(m map[string]*apollo.Value) *apollo.Value {
+	return &apollo.Value{Type: &apollo.Value_Map{Map: &apollo.MapValue{Values: m}}}
+}
+
+// valueSize estimates the memory held by v.
+func valueSize(v *apollo.Value) int {
+	switch x := v.GetType().(type) {
+	case *apollo.Value_String_:
+		return len(x.String_)
+	case *apollo.Value_Binary:
+		return len(x.Binary)
+	case *apollo.Value_List:
+		n := 0
+		for _, e := range x.List.GetValues() {
+			n += valueSize(e)
+		}
+		return n
+	case *apollo.Value_Map:
+		n := 0
+		for k, e := range x.Map.GetValues() {
+			n += len(k) + valueSize(e)
+		}
+		return n
+	}
+	return 8
+}